@@ -6,14 +6,17 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 
 	"github.com/scheedule/coursestore/db"
+	"github.com/scheedule/coursestore/types"
 )
 
 var (
@@ -27,26 +30,94 @@ var (
 	// an incoming request.
 	DecodeError = errors.New("Error unmarshalling data from the database")
 
+	// NoRunLogError returned when scrape runs are requested from a store that
+	// doesn't record them.
+	NoRunLogError = errors.New("The store does not record scrape runs")
+
 	// Mapping of errors to respective HTTP status codes
 	errorMap = map[error]int{
 		BadRequestError: http.StatusBadRequest,
 		DBError:         http.StatusNotFound,
 		DecodeError:     http.StatusInternalServerError,
+		NoRunLogError:   http.StatusNotImplemented,
 	}
 )
 
-// Type API contains the database to query and functions we use to query it.
+// Most recent scrape runs listed unless a limit is requested.
+const defaultRunLimit = 20
+
+// Type API contains the store to query and functions we use to query it.
 type API struct {
-	db *db.DB
+	db db.Store
 }
 
-// Construct a new API object with a pointer to a database to query.
-func New(database *db.DB) *API {
-	return &API{database}
+// Construct a new API object with the store to query.
+func New(store db.Store) *API {
+	return &API{store}
+}
+
+// This route lists every term the store holds classes for, oldest first, as
+// JSON.
+func (a *API) HandleTerms(w http.ResponseWriter, r *http.Request) {
+	terms, err := a.db.Terms()
+	if err != nil {
+		log.Warn("DB lookup failed: ", err)
+		handleError(w, DBError)
+		return
+	}
+
+	js, err := json.Marshal(terms)
+	if err != nil {
+		log.Error("terms marshal failed: ", err)
+		handleError(w, DecodeError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// This route lists the most recent scrape runs, newest first, as JSON. The
+// limit query parameter sets how many, 0 for every run recorded.
+func (a *API) HandleScrapes(w http.ResponseWriter, r *http.Request) {
+	runLog, ok := a.db.(db.RunLog)
+	if !ok {
+		handleError(w, NoRunLogError)
+		return
+	}
+
+	limit := defaultRunLimit
+	if value := r.FormValue("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			log.Debug("query does not contain a properly formatted limit")
+			handleError(w, BadRequestError)
+			return
+		}
+		limit = n
+	}
+
+	runs, err := runLog.Runs(limit)
+	if err != nil {
+		log.Warn("DB lookup failed: ", err)
+		handleError(w, DBError)
+		return
+	}
+
+	js, err := json.Marshal(runs)
+	if err != nil {
+		log.Error("scrape runs marshal failed: ", err)
+		handleError(w, DecodeError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
 }
 
 // This route handles all requests to lookup individual class data. Requests
 // will have a department and number and class data will be returned as JSON.
+// Routes with a year and semester look in that term, others in the latest.
 func (a *API) HandleSingle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	department := vars["department"]
@@ -63,7 +134,13 @@ func (a *API) HandleSingle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	class, err := a.db.LookupSingle(department, number, detailLevel)
+	term, err := a.requestTerm(r)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	class, err := a.db.LookupSingle(term, department, number, detailLevel)
 	if err != nil {
 		log.Warn("DB lookup failed: ", err)
 		handleError(w, DBError)
@@ -81,6 +158,9 @@ func (a *API) HandleSingle(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
+// This route handles requests for every class in a department. Data is
+// returned as JSON. Classes can be filtered by credit hours and GenEd, see
+// classFilter.
 func (a *API) HandleDepartment(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	department := vars["department"]
@@ -96,12 +176,25 @@ func (a *API) HandleDepartment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	classes, err := a.db.LookupDepartment(department, detailLevel)
+	term, err := a.requestTerm(r)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	keep, err := classFilter(r)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	classes, err := a.db.LookupDepartment(term, department, detailLevel)
 	if err != nil {
 		log.Warn("DB lookup failed: ", err)
 		handleError(w, DBError)
 		return
 	}
+	classes = filterClasses(classes, keep)
 
 	js, err := json.Marshal(classes)
 	if err != nil {
@@ -114,8 +207,9 @@ func (a *API) HandleDepartment(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
-// This route handles requests to get all the class data for every class in one
-// request. Data is returned as JSON.
+// This route handles requests to get all the class data for every class in a
+// term in one request. Data is returned as JSON. Classes can be filtered by
+// credit hours and GenEd, see classFilter.
 func (a *API) HandleAll(w http.ResponseWriter, r *http.Request) {
 
 	detailLevel := "basic"
@@ -123,12 +217,25 @@ func (a *API) HandleAll(w http.ResponseWriter, r *http.Request) {
 		detailLevel = "complete"
 	}
 
-	classes, err := a.db.LookupAll(detailLevel)
+	term, err := a.requestTerm(r)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	keep, err := classFilter(r)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	classes, err := a.db.LookupAll(term, detailLevel)
 
 	if err != nil {
 		log.Error("failed to query all classes: ", err)
 		return
 	}
+	classes = filterClasses(classes, keep)
 
 	w.Header().Set("Content-Encoding", "gzip")
 
@@ -139,6 +246,108 @@ func (a *API) HandleAll(w http.ResponseWriter, r *http.Request) {
 	gzWriter.Close()
 }
 
+// Resolve the term a request is for from its year and semester route
+// variables. Requests without them are for the latest term in the store.
+func (a *API) requestTerm(r *http.Request) (types.Term, error) {
+	vars := mux.Vars(r)
+	year, hasYear := vars["year"]
+	semester := vars["semester"]
+
+	if !hasYear {
+		terms, err := a.db.Terms()
+		if err != nil || len(terms) == 0 {
+			log.Warn("failed to find latest term: ", err)
+			return types.Term{}, DBError
+		}
+		return terms[len(terms)-1], nil
+	}
+
+	yearNum, err := strconv.Atoi(year)
+	if err != nil || !types.IsSemester(semester) {
+		log.Debug("query does not contain properly formatted year/semester combination")
+		return types.Term{}, BadRequestError
+	}
+
+	return types.Term{Year: yearNum, Semester: semester}, nil
+}
+
+// Return a filter keeping the classes a request asks for: those that can be
+// taken for the credit hours requested, see creditFilter, and that satisfy
+// the GenEd category or attribute code in the gened query parameter. Returns
+// nil if the request doesn't filter classes.
+func classFilter(r *http.Request) (func(types.Class) bool, error) {
+	credits, err := creditFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	genEd := strings.TrimSpace(r.FormValue("gened"))
+	if genEd == "" {
+		return credits, nil
+	}
+
+	return func(c types.Class) bool {
+		return c.SatisfiesGenEd(genEd) && (credits == nil || credits(c))
+	}, nil
+}
+
+// Return a filter keeping the classes that can be taken for the credit hours
+// requested. The credits query parameter asks for an exact number of hours,
+// min_credits and max_credits for a range. Returns nil if no credit hours
+// were requested.
+func creditFilter(r *http.Request) (func(types.Class) bool, error) {
+	min, max := 0.0, math.Inf(1)
+	requested := false
+
+	for _, param := range []struct {
+		name   string
+		bounds []*float64
+	}{
+		{"credits", []*float64{&min, &max}},
+		{"min_credits", []*float64{&min}},
+		{"max_credits", []*float64{&max}},
+	} {
+		value := r.FormValue(param.name)
+		if value == "" {
+			continue
+		}
+
+		hours, err := strconv.ParseFloat(value, 64)
+		if err != nil || hours < 0 {
+			log.Debug("query does not contain properly formatted credit hours")
+			return nil, BadRequestError
+		}
+		for _, bound := range param.bounds {
+			*bound = hours
+		}
+		requested = true
+	}
+
+	if !requested {
+		return nil, nil
+	}
+	if min > max {
+		log.Debug("query asks for more credit hours than it allows")
+		return nil, BadRequestError
+	}
+	return func(c types.Class) bool { return c.OffersCredits(min, max) }, nil
+}
+
+// Return the classes keep returns true for. A nil keep keeps every class.
+func filterClasses(classes []types.Class, keep func(types.Class) bool) []types.Class {
+	if keep == nil {
+		return classes
+	}
+
+	kept := make([]types.Class, 0, len(classes))
+	for _, class := range classes {
+		if keep(class) {
+			kept = append(kept, class)
+		}
+	}
+	return kept
+}
+
 // Write the appropriate message to the client.
 func handleError(w http.ResponseWriter, err error) {
 	http.Error(w, err.Error(), errorMap[err])
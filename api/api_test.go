@@ -6,8 +6,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"sort"
 	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
 
 	"github.com/scheedule/coursestore/db"
 	"github.com/scheedule/coursestore/types"
@@ -16,9 +19,27 @@ import (
 var testAPI *API
 
 func init() {
-	testDB := db.New(os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"), os.Getenv("DB_COLLECTION"))
-	testDB.Init()
-	testAPI = New(testDB)
+	testAPI = New(db.NewMemory())
+}
+
+// Route requests to testAPI the same way the serve command does.
+func testRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/lookup", testAPI.HandleAll)
+	r.HandleFunc("/lookup/{department}", testAPI.HandleDepartment)
+	r.HandleFunc("/lookup/{department}/{number:[0-9]+}", testAPI.HandleSingle)
+	r.HandleFunc("/terms", testAPI.HandleTerms)
+	r.HandleFunc("/buildings", testAPI.HandleBuildings)
+	r.HandleFunc("/buildings/{name}", testAPI.HandleBuilding)
+	r.HandleFunc("/status/scrapes", testAPI.HandleScrapes)
+
+	term := r.PathPrefix("/terms/{year:[0-9]+}/{semester}").Subrouter()
+	term.HandleFunc("/lookup", testAPI.HandleAll)
+	term.HandleFunc("/lookup/{department}", testAPI.HandleDepartment)
+	term.HandleFunc("/lookup/{department}/{number:[0-9]+}", testAPI.HandleSingle)
+	term.HandleFunc("/buildings", testAPI.HandleBuildings)
+	term.HandleFunc("/buildings/{name}", testAPI.HandleBuilding)
+	return r
 }
 
 var departmentTests = []struct {
@@ -36,7 +57,7 @@ func TestIsValidDepartment(t *testing.T) {
 	for _, tt := range departmentTests {
 		result := isValidDepartment(tt.in)
 		if result != tt.out {
-			t.Fatalf("isValidDepartment(%q) => %q, want %q", tt.in, result, tt.out)
+			t.Fatalf("isValidDepartment(%q) => %t, want %t", tt.in, result, tt.out)
 		}
 	}
 }
@@ -55,51 +76,69 @@ func TestIsValidCourseNumber(t *testing.T) {
 	for _, tt := range courseNumberTests {
 		result := isValidCourseNumber(tt.in)
 		if result != tt.out {
-			t.Fatalf("isValidCourseNumber(%q) => %q, want %q", tt.in, result, tt.out)
+			t.Fatalf("isValidCourseNumber(%q) => %t, want %t", tt.in, result, tt.out)
 		}
 	}
 }
 
 var sampleClass = types.Class{
+	Year:         2016,
+	Semester:     "fall",
+	Department:   "CS",
+	CourseNumber: 125,
+}
+
+var previousClass = types.Class{
+	Year:         2016,
+	Semester:     "spring",
 	Department:   "CS",
-	CourseNumber: "125",
+	CourseNumber: 225,
+}
+
+// Reset testAPI's store to hold sampleClass and previousClass.
+func fillStore(t *testing.T) {
+	testAPI.db.Purge()
+	for _, class := range []types.Class{previousClass, sampleClass} {
+		if err := testAPI.db.Put(class); err != nil {
+			t.Fatal("failed to put class in database: ", err)
+		}
+	}
 }
 
 var classLookupTests = []struct {
+	url        string
 	department string
 	number     string
 	code       string
 }{
-	{"CS", "125", "200"},
-	{"CS", "225", "404"},
+	{"/lookup/CS/125", "CS", "125", "200"},
+	{"/lookup/CS/225", "CS", "225", "404"},
+	{"/terms/2016/spring/lookup/CS/225", "CS", "225", "200"},
+	{"/terms/2016/fall/lookup/CS/225", "CS", "225", "404"},
+	{"/terms/2016/autumn/lookup/CS/225", "CS", "225", "400"},
 }
 
 func TestLookup(t *testing.T) {
-	// Input class into database
-	testAPI.db.Purge()
-	err := testAPI.db.Put(sampleClass)
-	if err != nil {
-		t.Fatal("failed to put class in database: ", err)
-	}
+	// Input classes into database
+	fillStore(t)
 
 	for _, tt := range classLookupTests {
 		// Make HTTP Request
-		urlStr := fmt.Sprintf("/lookup?department=%s&number=%s", tt.department, tt.number)
-		req, err := http.NewRequest("GET", urlStr, nil)
+		req, err := http.NewRequest("GET", tt.url, nil)
 		if err != nil {
 			t.Fatal("failed to create request object.")
 		}
 
 		w := httptest.NewRecorder()
-		testAPI.HandleLookup(w, req)
+		testRouter().ServeHTTP(w, req)
 
 		code := fmt.Sprintf("%d", w.Code)
 		if code != tt.code {
-			t.Fatalf("response code received %q, want %q", code, tt.code)
+			t.Fatalf("%s: response code received %q, want %q", tt.url, code, tt.code)
 		}
 
 		if code != "200" {
-			return
+			continue
 		}
 
 		data, err := ioutil.ReadAll(w.Body)
@@ -115,8 +154,198 @@ func TestLookup(t *testing.T) {
 		if proposal.Department != tt.department {
 			t.Fatalf("department contained %q, want %q", proposal.Department, tt.department)
 		}
-		if proposal.CourseNumber != tt.number {
-			t.Fatalf("course number contained %q, want %q", proposal.CourseNumber, tt.department)
+		if fmt.Sprint(proposal.CourseNumber) != tt.number {
+			t.Fatalf("course number contained %d, want %q", proposal.CourseNumber, tt.number)
+		}
+	}
+}
+
+var creditTests = []struct {
+	query   string
+	code    int
+	numbers []int
+}{
+	{"", http.StatusOK, []int{125, 173, 225, 498, 598}},
+	{"?credits=3", http.StatusOK, []int{173, 498}},
+	{"?credits=2", http.StatusOK, []int{498, 598}},
+	{"?min_credits=4", http.StatusOK, []int{225, 498, 598}},
+	{"?max_credits=2", http.StatusOK, []int{498, 598}},
+	{"?min_credits=3.5&max_credits=4", http.StatusOK, []int{225, 498, 598}},
+	{"?credits=three", http.StatusBadRequest, nil},
+	{"?min_credits=4&max_credits=3", http.StatusBadRequest, nil},
+}
+
+func TestLookupCredits(t *testing.T) {
+	fillStore(t)
+	for _, class := range []types.Class{
+		{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 173, CreditHours: "3", MinCreditHours: 3, MaxCreditHours: 3},
+		{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 225, CreditHours: "4", MinCreditHours: 4, MaxCreditHours: 4},
+		{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 498, CreditHours: "1-4", MinCreditHours: 1, MaxCreditHours: 4},
+		{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 598, CreditHours: "4", MinCreditHours: 4, MaxCreditHours: 4,
+			Sections: []types.Section{{CRN: 1, CreditHours: "2", MinCreditHours: 2, MaxCreditHours: 2}}},
+	} {
+		if err := testAPI.db.Put(class); err != nil {
+			t.Fatal("failed to put class in database: ", err)
+		}
+	}
+
+	for _, tt := range creditTests {
+		req, err := http.NewRequest("GET", "/lookup/CS"+tt.query, nil)
+		if err != nil {
+			t.Fatal("failed to create request object.")
+		}
+
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Fatalf("%s: response code received %d, want %d", tt.query, w.Code, tt.code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var classes []types.Class
+		if err = json.NewDecoder(w.Body).Decode(&classes); err != nil {
+			t.Fatal("failed to decode response: ", err)
+		}
+
+		var numbers []int
+		for _, class := range classes {
+			numbers = append(numbers, class.CourseNumber)
+		}
+		sort.Ints(numbers)
+		if fmt.Sprint(numbers) != fmt.Sprint(tt.numbers) {
+			t.Errorf("%s: received classes %v, want %v", tt.query, numbers, tt.numbers)
+		}
+	}
+}
+
+var genEdTests = []struct {
+	query   string
+	numbers []int
+}{
+	{"?gened=CS", []int{173, 498}},
+	{"?gened=1wcc", []int{173}},
+	{"?gened=QR", []int{225}},
+	{"?gened=HUM", nil},
+	{"?gened=CS&min_credits=4", []int{498}},
+}
+
+func TestLookupGenEd(t *testing.T) {
+	western := types.GenEd{Category: "CS", CategoryName: "Cultural Studies", Code: "1WCC", Name: "Western Comparative Cultures"}
+	nonWestern := types.GenEd{Category: "CS", CategoryName: "Cultural Studies", Code: "1NW", Name: "Non-Western Cultures"}
+	quantitative := types.GenEd{Category: "QR", CategoryName: "Quantitative Reasoning", Code: "1QR1", Name: "Quantitative Reasoning I"}
+
+	fillStore(t)
+	for _, class := range []types.Class{
+		{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 173, CreditHours: "3", MinCreditHours: 3, MaxCreditHours: 3, GenEds: []types.GenEd{western}},
+		{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 225, CreditHours: "4", MinCreditHours: 4, MaxCreditHours: 4, GenEds: []types.GenEd{quantitative}},
+		{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 498, CreditHours: "4", MinCreditHours: 4, MaxCreditHours: 4, GenEds: []types.GenEd{nonWestern}},
+	} {
+		if err := testAPI.db.Put(class); err != nil {
+			t.Fatal("failed to put class in database: ", err)
+		}
+	}
+
+	for _, tt := range genEdTests {
+		req, err := http.NewRequest("GET", "/lookup/CS"+tt.query, nil)
+		if err != nil {
+			t.Fatal("failed to create request object.")
+		}
+
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: response code received %d, want %d", tt.query, w.Code, http.StatusOK)
+		}
+
+		var classes []types.Class
+		if err = json.NewDecoder(w.Body).Decode(&classes); err != nil {
+			t.Fatal("failed to decode response: ", err)
+		}
+
+		var numbers []int
+		for _, class := range classes {
+			numbers = append(numbers, class.CourseNumber)
+		}
+		sort.Ints(numbers)
+		if fmt.Sprint(numbers) != fmt.Sprint(tt.numbers) {
+			t.Errorf("%s: received classes %v, want %v", tt.query, numbers, tt.numbers)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	fillStore(t)
+
+	req, err := http.NewRequest("GET", "/terms", nil)
+	if err != nil {
+		t.Fatal("failed to create request object.")
+	}
+
+	w := httptest.NewRecorder()
+	testRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("response code received %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var terms []types.Term
+	if err = json.NewDecoder(w.Body).Decode(&terms); err != nil {
+		t.Fatal("failed to decode response: ", err)
+	}
+
+	want := []types.Term{previousClass.Term(), sampleClass.Term()}
+	if len(terms) != len(want) || terms[0] != want[0] || terms[1] != want[1] {
+		t.Fatalf("terms contained %v, want %v", terms, want)
+	}
+}
+
+func TestScrapes(t *testing.T) {
+	runLog := testAPI.db.(db.RunLog)
+	for i := 0; i < 3; i++ {
+		run := types.ScrapeRun{
+			Start:        time.Date(2016, 1, 10+i, 0, 0, 0, 0, time.UTC),
+			ScrapeCounts: types.ScrapeCounts{Added: i},
+		}
+		if err := runLog.PutRun(run); err != nil {
+			t.Fatal("failed to put run in database: ", err)
+		}
+	}
+
+	var scrapeTests = []struct {
+		url  string
+		code int
+		runs int
+	}{
+		{"/status/scrapes", http.StatusOK, 3},
+		{"/status/scrapes?limit=1", http.StatusOK, 1},
+		{"/status/scrapes?limit=ten", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range scrapeTests {
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatal("failed to create request object.")
+		}
+
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Fatalf("%s: response code received %d, want %d", tt.url, w.Code, tt.code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var runs []types.ScrapeRun
+		if err = json.NewDecoder(w.Body).Decode(&runs); err != nil {
+			t.Fatal("failed to decode response: ", err)
+		}
+		if len(runs) != tt.runs {
+			t.Fatalf("%s: received %d runs, want %d", tt.url, len(runs), tt.runs)
+		}
+		if runs[0].Added != 2 {
+			t.Errorf("%s: first run added %d, want the newest run", tt.url, runs[0].Added)
 		}
 	}
 }
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// This route lists every building meetings are held in during a term, with
+// the rooms they are held in, as JSON. Routes with a year and semester look
+// in that term, others in the latest.
+func (a *API) HandleBuildings(w http.ResponseWriter, r *http.Request) {
+	meetings, err := a.buildingMeetings(r)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	buildings := make(map[string]*types.Building)
+	rooms := make(map[string]map[string]bool)
+	for _, m := range meetings {
+		name := m.Meeting.Building
+		building, ok := buildings[name]
+		if !ok {
+			building = &types.Building{Name: name, Rooms: []string{}}
+			buildings[name] = building
+			rooms[name] = make(map[string]bool)
+		}
+
+		building.Meetings++
+		if room := m.Meeting.Room; room != "" && !rooms[name][room] {
+			rooms[name][room] = true
+			building.Rooms = append(building.Rooms, room)
+		}
+	}
+
+	list := make([]types.Building, 0, len(buildings))
+	for _, building := range buildings {
+		sort.Strings(building.Rooms)
+		list = append(list, *building)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	js, err := json.Marshal(list)
+	if err != nil {
+		log.Error("buildings marshal failed: ", err)
+		handleError(w, DecodeError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// This route lists every meeting held in the named building during a term,
+// by room and start time, as JSON. Names are matched regardless of case.
+func (a *API) HandleBuilding(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(mux.Vars(r)["name"])
+	if name == "" {
+		handleError(w, BadRequestError)
+		return
+	}
+
+	meetings, err := a.buildingMeetings(r)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	var held []types.BuildingMeeting
+	for _, m := range meetings {
+		if strings.EqualFold(m.Meeting.Building, name) {
+			held = append(held, m)
+		}
+	}
+	if len(held) == 0 {
+		log.Debug("no meetings in building: ", name)
+		handleError(w, DBError)
+		return
+	}
+
+	sort.SliceStable(held, func(i, j int) bool {
+		a, b := held[i].Meeting, held[j].Meeting
+		if a.Room != b.Room {
+			return a.Room < b.Room
+		}
+		return a.StartMinutes < b.StartMinutes
+	})
+
+	js, err := json.Marshal(held)
+	if err != nil {
+		log.Error("building meetings marshal failed: ", err)
+		handleError(w, DecodeError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// Return every meeting held in a building during the term a request is for.
+func (a *API) buildingMeetings(r *http.Request) ([]types.BuildingMeeting, error) {
+	term, err := a.requestTerm(r)
+	if err != nil {
+		return nil, err
+	}
+
+	classes, err := a.db.LookupAll(term, "buildings")
+	if err != nil {
+		log.Warn("DB lookup failed: ", err)
+		return nil, DBError
+	}
+
+	var meetings []types.BuildingMeeting
+	for _, class := range classes {
+		for _, section := range class.Sections {
+			for _, meeting := range section.Meetings {
+				if meeting.Building == "" {
+					continue
+				}
+				meetings = append(meetings, types.BuildingMeeting{
+					Department:   class.Department,
+					CourseNumber: class.CourseNumber,
+					Name:         class.Name,
+					CRN:          section.CRN,
+					Code:         section.Code,
+					Meeting:      meeting,
+				})
+			}
+		}
+	}
+
+	return meetings, nil
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// Return a fifty minute meeting in room of building starting at start
+// minutes.
+func meetingIn(building, room string, start int) types.Meeting {
+	return types.Meeting{
+		Building:     building,
+		Room:         room,
+		StartMinutes: start,
+		EndMinutes:   start + 50,
+	}
+}
+
+// Reset testAPI's store to hold classes meeting in a few buildings.
+func fillBuildings(t *testing.T) {
+	fillStore(t)
+
+	classes := []types.Class{
+		{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 173, Sections: []types.Section{
+			{CRN: 1, Code: "AL1", Meetings: []types.Meeting{meetingIn("Siebel Center", "1404", 600)}},
+			{CRN: 2, Code: "AYA", Meetings: []types.Meeting{meetingIn("Siebel Center", "0218", 540),
+				{StartMinutes: types.NoTime, EndMinutes: types.NoTime}}},
+		}},
+		{Year: 2016, Semester: "fall", Department: "MATH", CourseNumber: 241, Sections: []types.Section{
+			{CRN: 3, Code: "BL1", Meetings: []types.Meeting{meetingIn("Altgeld Hall", "314", 540)}},
+			{CRN: 4, Code: "BL2", Meetings: []types.Meeting{meetingIn("Siebel Center", "1404", 480)}},
+		}},
+	}
+	for _, class := range classes {
+		if err := testAPI.db.Put(class); err != nil {
+			t.Fatal("failed to put class in database: ", err)
+		}
+	}
+}
+
+func TestBuildings(t *testing.T) {
+	fillBuildings(t)
+
+	req, err := http.NewRequest("GET", "/buildings", nil)
+	if err != nil {
+		t.Fatal("failed to create request object.")
+	}
+
+	w := httptest.NewRecorder()
+	testRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("response code received %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var buildings []types.Building
+	if err = json.NewDecoder(w.Body).Decode(&buildings); err != nil {
+		t.Fatal("failed to decode response: ", err)
+	}
+
+	want := "[{Altgeld Hall [314] 1} {Siebel Center [0218 1404] 3}]"
+	if fmt.Sprint(buildings) != want {
+		t.Errorf("buildings contained %v, want %s", buildings, want)
+	}
+}
+
+var buildingTests = []struct {
+	url  string
+	code int
+	crns []int
+}{
+	{"/buildings/Siebel%20Center", http.StatusOK, []int{2, 4, 1}},
+	{"/buildings/altgeld%20hall", http.StatusOK, []int{3}},
+	{"/buildings/Loomis%20Laboratory", http.StatusNotFound, nil},
+	{"/terms/2016/spring/buildings/Siebel%20Center", http.StatusNotFound, nil},
+}
+
+func TestBuilding(t *testing.T) {
+	fillBuildings(t)
+
+	for _, tt := range buildingTests {
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatal("failed to create request object.")
+		}
+
+		w := httptest.NewRecorder()
+		testRouter().ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Fatalf("%s: response code received %d, want %d", tt.url, w.Code, tt.code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var meetings []types.BuildingMeeting
+		if err = json.Unmarshal(w.Body.Bytes(), &meetings); err != nil {
+			t.Fatal("failed to decode response: ", err)
+		}
+		var locations []struct {
+			Meeting struct {
+				Location types.Location `json:"location"`
+			} `json:"meeting"`
+		}
+		if err = json.Unmarshal(w.Body.Bytes(), &locations); err != nil {
+			t.Fatal("failed to decode response locations: ", err)
+		}
+
+		var crns []int
+		for i, m := range meetings {
+			crns = append(crns, m.CRN)
+			if location := locations[i].Meeting.Location; location != m.Meeting.Location() {
+				t.Errorf("%s: meeting of section %d at %+v, want %+v", tt.url, m.CRN, location, m.Meeting.Location())
+			}
+		}
+		if fmt.Sprint(crns) != fmt.Sprint(tt.crns) {
+			t.Errorf("%s: received meetings of sections %v, want %v", tt.url, crns, tt.crns)
+		}
+	}
+}
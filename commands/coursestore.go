@@ -1,6 +1,12 @@
 package commands
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -16,7 +22,8 @@ var coursestoreCmd = &cobra.Command{
 }
 
 var verbose bool
-var termURL, servePort, dbHost, dbPort, database, collection string
+var termURLs []string
+var servePort, dbHost, dbPort, database, collection, storeType, storePath string
 
 //Initializes flags
 func init() {
@@ -27,6 +34,9 @@ func addCommands() {
 	coursestoreCmd.AddCommand(versionCmd)
 	coursestoreCmd.AddCommand(scrapeCmd)
 	coursestoreCmd.AddCommand(serveCmd)
+	coursestoreCmd.AddCommand(rollbackCmd)
+	coursestoreCmd.AddCommand(termsCmd)
+	coursestoreCmd.AddCommand(scrapeHistoryCmd)
 }
 
 func initializeConfig() {
@@ -35,6 +45,36 @@ func initializeConfig() {
 	}
 }
 
+// Return a context canceled on SIGINT or SIGTERM, or once timeout passes if
+// it is positive. A second signal is left to kill the process as usual.
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+
+		stop := cancel
+		cancel = func() {
+			cancelTimeout()
+			stop()
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Warn("received ", sig, ", stopping")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
 func Execute() {
 	addCommands()
 	if err := coursestoreCmd.Execute(); err != nil {
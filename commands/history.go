@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/scheedule/coursestore/db"
+)
+
+var historyLimit int
+
+var scrapeHistoryCmd = &cobra.Command{
+	Use:   "scrape-history",
+	Short: "List recent scrapes",
+	Long: "List the most recent scrapes into the store, newest first, with " +
+		"the changes they made and how they ended.",
+	Run: func(cmd *cobra.Command, args []string) {
+		initializeConfig()
+
+		historyDB, err := openStore()
+		if err != nil {
+			log.Fatal("Failed to initialize database connection:", err)
+		}
+		defer historyDB.Close()
+
+		runLog, ok := historyDB.(db.RunLog)
+		if !ok {
+			log.Fatal("Store ", storeType, " does not record scrapes")
+		}
+
+		runs, err := runLog.Runs(historyLimit)
+		if err != nil {
+			log.Fatal("Failed to list scrapes: ", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "START\tDURATION\tTERMS\tADDED\tUPDATED\tREMOVED\tFAILED\tREQUESTS\tBYTES\tRESULT")
+		for _, run := range runs {
+			result := "ok"
+			if run.Error != "" {
+				result = run.Error
+			}
+
+			fmt.Fprintf(w, "%s\t%v\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+				run.Start.Local().Format(time.RFC3339), run.End.Sub(run.Start).Round(time.Second),
+				strings.Join(run.TermURLs, ","), run.Added, run.Updated, run.Removed, run.Failed,
+				run.Requests, run.BytesFetched, result)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	scrapeHistoryCmd.Flags().IntVarP(
+		&historyLimit, "limit", "n", 10, "Most scrapes to list. 0 for every scrape recorded.")
+
+	scrapeHistoryCmd.Flags().StringVarP(
+		&storeType, "store", "", "mongo", "Store backend to use: mongo, bolt or memory.")
+
+	scrapeHistoryCmd.Flags().StringVarP(
+		&storePath, "store_path", "", "coursestore.db", "Path to the bolt store file.")
+
+	scrapeHistoryCmd.Flags().StringVarP(
+		&dbHost, "db_host", "", "localhost", "Hostname of DB to read from.")
+
+	scrapeHistoryCmd.Flags().StringVarP(
+		&dbPort, "db_port", "", "27017", "Port to access DB on.")
+
+	scrapeHistoryCmd.Flags().StringVarP(
+		&database, "db_name", "", "test", "Database name.")
+
+	scrapeHistoryCmd.Flags().StringVarP(
+		&collection, "db_collection", "", "classes", "Collection in database holding classes.")
+}
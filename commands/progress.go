@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/scheedule/coursestore/scrape"
+)
+
+// Report scrape progress as a line rewritten in place when stderr is a
+// terminal, and as structured log entries otherwise. Returns the reporting
+// function and how often it should be called.
+func progressReporter() (func(scrape.Progress), time.Duration) {
+	if !isTerminal(os.Stderr) {
+		return func(p scrape.Progress) {
+			log.WithFields(p.Fields()).Info("scrape progress")
+		}, 30 * time.Second
+	}
+
+	return func(p scrape.Progress) {
+		fmt.Fprintf(os.Stderr, "\r%s\x1b[K", progressLine(p))
+		if p.Done {
+			fmt.Fprintln(os.Stderr)
+		}
+	}, time.Second
+}
+
+// Summarize progress on one line.
+func progressLine(p scrape.Progress) string {
+	eta := "unknown"
+	if p.ETA > 0 {
+		eta = p.ETA.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%s %d: %d/%d subjects, %d/%d courses, %d failed, %d in flight, %.1f courses/s, ETA %s",
+		p.Term.Semester, p.Term.Year, p.SubjectsDone, p.Subjects,
+		p.CoursesDone, p.Courses, p.CoursesFailed, p.InFlight, p.Throughput, eta)
+}
+
+// Return true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,55 @@
+package commands
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/scheedule/coursestore/db"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore previous courses",
+	Long: "Restore the classes replaced by the last scrape. Rolling back " +
+		"again restores the classes that were rolled back.",
+	Run: func(cmd *cobra.Command, args []string) {
+		initializeConfig()
+
+		rollbackDB, err := openStore()
+		if err != nil {
+			log.Fatal("Failed to initialize database connection:", err)
+		}
+		defer rollbackDB.Close()
+
+		generational, ok := rollbackDB.(db.Generational)
+		if !ok {
+			log.Fatal("Store ", storeType, " does not keep previous generations")
+		}
+
+		if err = generational.Rollback(); err != nil {
+			log.Fatal("Failed to roll back: ", err)
+		}
+
+		log.Info("rolled back to previous generation")
+	},
+}
+
+func init() {
+	rollbackCmd.Flags().StringVarP(
+		&storeType, "store", "", "mongo", "Store backend to use: mongo, bolt or memory.")
+
+	rollbackCmd.Flags().StringVarP(
+		&storePath, "store_path", "", "coursestore.db", "Path to the bolt store file.")
+
+	rollbackCmd.Flags().StringVarP(
+		&dbHost, "db_host", "", "localhost", "Hostname of DB to roll back.")
+
+	rollbackCmd.Flags().StringVarP(
+		&dbPort, "db_port", "", "27017", "Port to access DB on.")
+
+	rollbackCmd.Flags().StringVarP(
+		&database, "db_name", "", "test", "Database name.")
+
+	rollbackCmd.Flags().StringVarP(
+		&collection, "db_collection", "", "classes", "Collection in database holding classes.")
+}
@@ -1,6 +1,14 @@
 package commands
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/spf13/cobra"
 
@@ -9,6 +17,24 @@ import (
 	"github.com/scheedule/coursestore/types"
 )
 
+// EmptyScrapeError is returned when a scrape produced no classes, in which
+// case the live classes are left in place.
+var EmptyScrapeError = errors.New("Scrape produced no classes")
+
+var failFast bool
+var maxErrors int
+var timeout time.Duration
+var userAgent string
+var concurrency, burst int
+var rate float64
+var cacheDir string
+var offline, conditional bool
+var checkpointPath string
+var resume bool
+var departments, courses []string
+var validate string
+var thresholds []string
+
 var scrapeCmd = &cobra.Command{
 	Use:   "scrape",
 	Short: "Fetch courses",
@@ -16,17 +42,179 @@ var scrapeCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		initializeConfig()
 
-		if err := PopulateDB(termURL, dbHost, dbPort, database, collection); err != nil {
+		if offline && cacheDir == "" {
+			log.Fatal("offline scrapes need a cache_dir to read from")
+		}
+		if conditional && cacheDir == "" {
+			log.Fatal("conditional scrapes need a cache_dir to keep documents in")
+		}
+
+		scrapeDB, err := openStore()
+		if err != nil {
+			log.Fatal("Failed to initialize database connection:", err)
+		}
+		defer scrapeDB.Close()
+
+		ctx, cancel := commandContext(timeout)
+		defer cancel()
+
+		var validators scrape.ValidatorStore
+		if conditional {
+			validatorLog, ok := scrapeDB.(db.ValidatorLog)
+			if !ok {
+				log.Fatal("Store ", storeType, " does not keep validators for conditional scrapes")
+			}
+			validators = validatorLog
+		}
+		scraper := newScraper(validators)
+
+		urls := termURLs
+		if discover != "" {
+			urls, err = discoverTerms(ctx, scraper, discover, scrapeDB)
+			if err != nil {
+				log.Fatal("Failed to discover terms: ", err)
+			}
+			if len(urls) == 0 {
+				fmt.Println("no terms to scrape")
+				return
+			}
+		}
+
+		filter, err := parseFilter(departments, courses)
+		if err != nil {
+			log.Fatal("Failed to parse courses: ", err)
+		}
+
+		checkpoint := scrape.NewCheckpoint(checkpointPath, filter)
+		if resume {
+			checkpoint, err = scrape.LoadCheckpoint(checkpointPath, filter)
+			if err != nil {
+				log.Fatal("Failed to load checkpoint: ", err)
+			}
+		}
+
+		options := PopulateOptions{
+			Policy: scrape.ErrorPolicy{
+				FailFast:  failFast,
+				MaxErrors: maxErrors,
+			},
+			Checkpoint: checkpoint,
+			Filter:     filter,
+		}
+		options.OnProgress, options.ProgressInterval = progressReporter()
+
+		switch validate {
+		case "fail":
+			options.Validate = true
+			options.Thresholds, err = parseThresholds(thresholds)
+			if err != nil {
+				log.Fatal("Failed to parse thresholds: ", err)
+			}
+		case "warn":
+			options.Validate = true
+		case "off":
+		default:
+			log.Fatal("validate must be fail, warn or off, not ", validate)
+		}
+
+		run, err := PopulateDB(ctx, scraper, urls, scrapeDB, options)
+		if err != nil {
 			log.Fatal(err)
 		}
+
+		fmt.Printf("added %d, updated %d, unchanged %d, not modified %d, removed %d classes, %d failed\n",
+			run.Added, run.Updated, run.Unchanged, run.NotModified, run.Removed, run.Failed)
+		fmt.Printf("%d classes changed\n", run.Changed())
+		fmt.Printf("%d requests, %d bytes fetched in %v\n",
+			run.Requests, run.BytesFetched, run.End.Sub(run.Start))
 	},
 }
 
 func init() {
+	scrapeCmd.Flags().StringSliceVarP(
+		&termURLs, "term_url", "t",
+		[]string{"http://courses.illinois.edu/cisapp/explorer/schedule/2016/spring.xml"},
+		"URL to term XML. Repeat to scrape several terms.")
+
+	scrapeCmd.Flags().StringVarP(
+		&discover, "discover", "", "",
+		"Scrape terms found in the schedule instead of term_url: latest or unscraped.")
+
+	scrapeCmd.Flags().BoolVarP(
+		&failFast, "fail_fast", "", false, "Stop at the first course that fails to scrape.")
+
+	scrapeCmd.Flags().IntVarP(
+		&maxErrors, "max_errors", "", 25,
+		"Stop once more than this many courses fail to scrape. 0 for no limit.")
+
+	scrapeCmd.Flags().StringSliceVarP(
+		&departments, "departments", "", nil,
+		"Only scrape these departments, e.g. CS,MATH. Other classes are left as they are.")
+
+	scrapeCmd.Flags().StringSliceVarP(
+		&courses, "courses", "", nil,
+		"Only scrape these courses, e.g. \"CS 225,MATH 241\". Other classes are left as they are.")
+
+	scrapeCmd.Flags().StringVarP(
+		&validate, "validate", "", "fail",
+		"Check the scraped classes: fail to keep the live classes if too many fail a check, "+
+			"warn to only log them, or off.")
+
+	scrapeCmd.Flags().StringSliceVarP(
+		&thresholds, "thresholds", "", nil,
+		"Largest fraction of classes allowed to fail a check, e.g. no_meetings=0.1. Checks are "+
+			strings.Join(scrape.Checks, ", ")+".")
+
+	scrapeCmd.Flags().StringVarP(
+		&checkpointPath, "checkpoint", "", "coursestore.checkpoint.json",
+		"File recording the progress of the scrape, removed once it succeeds.")
+
+	scrapeCmd.Flags().BoolVarP(
+		&resume, "resume", "", false,
+		"Continue the scrape recorded in checkpoint instead of starting over. "+
+			"Pass the same terms, departments and courses as the interrupted scrape.")
+
+	scrapeCmd.Flags().DurationVarP(
+		&timeout, "timeout", "", 0,
+		"Stop the scrape if it runs longer than this, e.g. 30m. 0 for no limit.")
+
+	scrapeCmd.Flags().IntVarP(
+		&concurrency, "concurrency", "", scrape.DefaultConcurrency,
+		"Most requests to the course API in flight at once.")
+
+	scrapeCmd.Flags().Float64VarP(
+		&rate, "rate", "", 10,
+		"Most requests to the course API started per second, lowered automatically "+
+			"while it is overloaded. 0 for no limit.")
+
+	scrapeCmd.Flags().IntVarP(
+		&burst, "burst", "", 0,
+		"Most requests started at once after a lull. 0 for one second's worth of rate.")
+
+	scrapeCmd.Flags().StringVarP(
+		&cacheDir, "cache_dir", "", "",
+		"Directory to save every fetched XML document to, for replaying with offline.")
+
+	scrapeCmd.Flags().BoolVarP(
+		&offline, "offline", "", false,
+		"Scrape only from the XML saved in cache_dir, without contacting the course API.")
+
+	scrapeCmd.Flags().BoolVarP(
+		&conditional, "conditional", "", false,
+		"Only download courses that changed since the last scrape into this store, "+
+			"using the validators kept in the store and the XML kept in cache_dir.")
+
 	scrapeCmd.Flags().StringVarP(
-		&termURL, "term_url", "t",
-		"http://courses.illinois.edu/cisapp/explorer/schedule/2016/spring.xml",
-		"URL to term XML.")
+		&userAgent, "user_agent", "", "", "User-Agent header to send to the course API.")
+
+	scrapeCmd.Flags().StringVarP(
+		&scheduleURL, "schedule_url", "", scrape.ScheduleURL, "URL to schedule XML.")
+
+	scrapeCmd.Flags().StringVarP(
+		&storeType, "store", "", "mongo", "Store backend to use: mongo, bolt or memory.")
+
+	scrapeCmd.Flags().StringVarP(
+		&storePath, "store_path", "", "coursestore.db", "Path to the bolt store file.")
 
 	scrapeCmd.Flags().StringVarP(
 		&dbHost, "host", "", "localhost", "Hostname of DB to insert into.")
@@ -41,32 +229,487 @@ func init() {
 		&collection, "collection", "", "classes", "Collection in database to insert classes.")
 }
 
-// Populate the selected database with the data scraped from the term URL.
-func PopulateDB(termURL, ip, port, dbName, collectionName string) error {
-	scrapeDB := db.New(ip, port, dbName, collectionName)
+// Build the scraper configured by the command line flags. Conditional scrapes
+// keep their validators in validators.
+func newScraper(validators scrape.ValidatorStore) *scrape.Scraper {
+	header := http.Header{}
+	if userAgent != "" {
+		header.Set("User-Agent", userAgent)
+	}
+
+	return scrape.New(scrape.Config{
+		Concurrency: concurrency,
+		Rate:        rate,
+		Burst:       burst,
+		Header:      header,
+		CacheDir:    cacheDir,
+		Offline:     offline,
+		Conditional: conditional,
+		Validators:  validators,
+	})
+}
+
+// Identifies a class in the store.
+type classKey struct {
+	term       types.Term
+	department string
+	number     int
+}
+
+// PopulateOptions controls how PopulateDB scrapes.
+type PopulateOptions struct {
+	Policy scrape.ErrorPolicy
+
+	// Records progress so an interrupted scrape can be resumed, and holds
+	// the progress of the scrape to resume if it isn't empty. Nil to record
+	// nothing.
+	Checkpoint *scrape.Checkpoint
+
+	// Departments and courses to scrape. Only classes it selects are
+	// updated or removed.
+	Filter scrape.Filter
+
+	// Check the classes Filter selects in the scraped terms once every term
+	// is scraped, warning of those failing a check.
+	Validate bool
+
+	// Largest fraction of classes allowed to fail each check before the
+	// staged classes are rejected. Nil to only warn.
+	Thresholds scrape.Thresholds
+
+	// Called with the progress of each term's scrape every
+	// ProgressInterval. Nil to report nothing.
+	OnProgress       func(scrape.Progress)
+	ProgressInterval time.Duration
+}
+
+// Build the filter selecting the departments and courses given on the
+// command line.
+func parseFilter(departments, courses []string) (scrape.Filter, error) {
+	var filter scrape.Filter
+	for _, department := range departments {
+		filter.Departments = append(filter.Departments, strings.ToUpper(strings.TrimSpace(department)))
+	}
+
+	for _, course := range courses {
+		id, err := scrape.ParseCourseID(course)
+		if err != nil {
+			return filter, fmt.Errorf("bad course %q: %v", course, err)
+		}
+		filter.Courses = append(filter.Courses, id)
+	}
+
+	return filter, nil
+}
+
+// Build the validation thresholds from the defaults and the check=fraction
+// overrides given on the command line.
+func parseThresholds(overrides []string) (scrape.Thresholds, error) {
+	thresholds := make(scrape.Thresholds)
+	for check, limit := range scrape.DefaultThresholds {
+		thresholds[check] = limit
+	}
+
+	for _, override := range overrides {
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad threshold %q, want check=fraction", override)
+		}
+
+		check := strings.TrimSpace(parts[0])
+		if _, ok := thresholds[check]; !ok {
+			return nil, fmt.Errorf("unknown check %q", check)
+		}
+
+		limit, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || limit < 0 || limit > 1 {
+			return nil, fmt.Errorf("bad threshold %q, want a fraction between 0 and 1", override)
+		}
+		thresholds[check] = limit
+	}
+
+	return thresholds, nil
+}
+
+// Populate the given store with the data scraper fetches from each term URL.
+// Classes are upserted by term, department and course number, and classes
+// of the scraped terms that are no longer offered are removed afterwards, so
+// unchanged classes keep their documents and ids and other terms are left
+// alone. Stores implementing db.Generational are scraped into their staging
+// generation, which is only swapped live once every term is scraped and
+// validated: if options.Validate is set, the staged classes of the scraped
+// terms options.Filter selects must not fail any check more often than
+// options.Thresholds allows.
+// Canceling ctx stops the scrape without swapping anything live. The
+// scraper's validators are saved once the classes are in place. The returned
+// run records what the scrape did, and is kept in stores implementing
+// db.RunLog whether or not the scrape succeeded.
+func PopulateDB(ctx context.Context, scraper *scrape.Scraper, termURLs []string, store db.Store, options PopulateOptions) (run types.ScrapeRun, err error) {
+	checkpoint := options.Checkpoint
+
+	run = types.ScrapeRun{
+		Start:       time.Now(),
+		TermURLs:    termURLs,
+		Departments: make(map[string]types.ScrapeCounts),
+	}
+	defer func() {
+		finishRun(&run, scraper.Stats(), err)
+		recordRun(store, run)
+	}()
+
+	scrapeDB := store
+	generational, staged := store.(db.Generational)
+	if staged {
+		staging, err := openStaging(generational, checkpoint)
+		if err != nil {
+			return run, err
+		}
+		defer staging.Close()
+		scrapeDB = staging
+	}
+
+	// Keep the progress made if the scrape doesn't finish.
+	defer func() {
+		if err == nil {
+			return
+		}
+		if saveErr := checkpoint.Save(); saveErr != nil {
+			log.Error("failed to save checkpoint: ", saveErr)
+		}
+	}()
+
+	var scraped []types.Term
+	for _, termURL := range termURLs {
+		progress := checkpoint.Term(termURL)
+		if progress.Done() {
+			log.Info("skipping term already scraped: ", termURL)
+			scraped = append(scraped, progress.Scraped())
+			continue
+		}
 
-	err := scrapeDB.Init()
+		var term types.Term
+		term, err = populateTerm(ctx, scraper, termURL, scrapeDB, options, progress, &run)
+		if err != nil {
+			return run, err
+		}
+		scraped = append(scraped, term)
+		progress.Finish(term)
+		if err = checkpoint.Save(); err != nil {
+			return run, err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"added":        run.Added,
+		"updated":      run.Updated,
+		"unchanged":    run.Unchanged,
+		"not_modified": run.NotModified,
+		"removed":      run.Removed,
+		"failed":       run.Failed,
+	}).Debug("finished populating database")
+
+	var validator *scrape.Validator
+	if options.Validate {
+		if validator, err = validateTerms(scrapeDB, scraped, options.Filter); err != nil {
+			return run, err
+		}
+	}
+
+	if err = checkClasses(validator, options.Thresholds, staged, &run); err != nil {
+		// The classes are rejected, so resuming must scrape them again.
+		checkpoint.Reset()
+		return run, err
+	}
+
+	if staged {
+		if err = validateStaged(scrapeDB); err != nil {
+			return run, err
+		}
+
+		log.Debug("swapping staged classes into place")
+		if err = generational.Swap(); err != nil {
+			return run, err
+		}
+	}
+
+	if err := checkpoint.Remove(); err != nil {
+		log.Warn("failed to remove checkpoint: ", err)
+	}
+	return run, scraper.SaveValidators()
+}
+
+// Check every class store holds in each of terms that filter selects. Classes
+// the scrape didn't touch aren't its to reject.
+func validateTerms(store db.Store, terms []types.Term, filter scrape.Filter) (*scrape.Validator, error) {
+	validator := scrape.NewValidator()
+
+	checked := make(map[types.Term]bool)
+	for _, term := range terms {
+		if checked[term] {
+			continue
+		}
+		checked[term] = true
+
+		classes, err := store.LookupAll(term, "complete")
+		if err != nil {
+			return nil, err
+		}
+		for _, class := range classes {
+			if filter.Course(scrape.CourseID{Department: class.Department, Number: class.CourseNumber}) {
+				validator.Check(class)
+			}
+		}
+	}
+
+	return validator, nil
+}
+
+// Log the checks the classes validator saw failed and record them in run.
+// Returns an error if staged classes fail a check more often than thresholds
+// allows. Classes stored without staging are already live, so are only
+// warned about.
+func checkClasses(validator *scrape.Validator, thresholds scrape.Thresholds, staged bool, run *types.ScrapeRun) error {
+	if validator == nil {
+		return nil
+	}
+
+	result := validator.Result()
+	for _, check := range scrape.Checks {
+		if result.Failed[check] == 0 {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"check":    check,
+			"failed":   result.Failed[check],
+			"classes":  result.Classes,
+			"examples": result.Examples[check],
+		}).Warn("scraped classes failed validation check")
+	}
+	run.Invalid = result.Failed
+
+	if !staged {
+		return nil
+	}
+	return result.Enforce(thresholds)
+}
+
+// Fill in how run ended from the requests made and the error it stopped with.
+func finishRun(run *types.ScrapeRun, stats scrape.FetchStats, err error) {
+	run.End = time.Now()
 	if err != nil {
-		return err
+		run.Error = err.Error()
+	}
+
+	run.Requests = stats.Requests
+	run.BytesFetched = stats.Bytes
+	run.Statuses = make(map[string]int, len(stats.Statuses))
+	for status, n := range stats.Statuses {
+		run.Statuses[strconv.Itoa(status)] = n
+	}
+}
+
+// Keep run in store if it implements db.RunLog. A run that can't be kept
+// doesn't fail the scrape it records.
+func recordRun(store db.Store, run types.ScrapeRun) {
+	runLog, ok := store.(db.RunLog)
+	if !ok {
+		return
+	}
+
+	if err := runLog.PutRun(run); err != nil {
+		log.Warn("failed to record scrape run: ", err)
+	}
+}
+
+// Update the counts of run and of department in it with f. Changes to no
+// department in particular only update the totals.
+func tally(run *types.ScrapeRun, department string, f func(c *types.ScrapeCounts)) {
+	f(&run.ScrapeCounts)
+	if department == "" {
+		return
+	}
+
+	counts := run.Departments[department]
+	f(&counts)
+	run.Departments[department] = counts
+}
+
+// Open the staging generation to scrape into. A checkpoint holding progress
+// resumes the staging generation it was recorded against, or starts over if
+// nothing is staged any more.
+func openStaging(g db.Generational, checkpoint *scrape.Checkpoint) (db.Store, error) {
+	if !checkpoint.Empty() {
+		staging, err := g.ResumeStaging()
+		if err != db.NothingStaged {
+			if err == nil {
+				log.Info("resuming scrape from checkpoint")
+			}
+			return staging, err
+		}
+
+		log.Warn("nothing staged to resume, starting the scrape over")
+		checkpoint.Reset()
 	}
 
-	log.Debug("purging database")
-	scrapeDB.Purge()
+	return g.Staging()
+}
+
+// Scrape the term at termURL into scrapeDB, returning the term it held and
+// adding the changes made and the failures met to run. Classes are only
+// removed from departments that scraped without failures, and only if the
+// filter selects them, so a skipped course is never mistaken for a dropped
+// one. Stored courses are recorded in progress, and the checkpoint is saved
+// each time a subject completes.
+func populateTerm(ctx context.Context, scraper *scrape.Scraper, termURL string, scrapeDB db.Store, options PopulateOptions,
+	progress *scrape.TermProgress, run *types.ScrapeRun) (types.Term, error) {
+	term, err := scraper.GetXML(ctx, termURL)
+	if err != nil {
+		return types.Term{}, err
+	}
 
-	term, err := scrape.GetXML(termURL)
+	// Canceled to stop digestion early after a store failure.
+	digestCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	courseChan := make(chan types.Class)
 
-	go scrape.DigestAll(term, courseChan)
+	var report scrape.Report
+	digestErr := make(chan error, 1)
+	go func() {
+		var err error
+		report, err = scraper.DigestAll(digestCtx, term, courseChan, scrape.Options{
+			ErrorPolicy: options.Policy,
+			Resume:      progress,
+			Filter:      options.Filter,
+
+			OnProgress:       options.OnProgress,
+			ProgressInterval: options.ProgressInterval,
+		})
+		digestErr <- err
+	}()
 
+	seen := make(map[classKey]bool)
+	var storeErr error
 	for class := range courseChan {
-		err = scrapeDB.Put(class)
+		// Keep draining after a store failure until digestion stops.
+		if storeErr != nil {
+			continue
+		}
+
+		change, err := scrapeDB.Upsert(class)
 		if err != nil {
-			return err
+			storeErr = err
+			cancel()
+			continue
+		}
+
+		seen[classKey{class.Term(), class.Department, class.CourseNumber}] = true
+		if progress.Complete(scrape.CourseID{Department: class.Department, Number: class.CourseNumber}) {
+			if err := options.Checkpoint.Save(); err != nil {
+				log.Warn("failed to save checkpoint: ", err)
+			}
+		}
+
+		tally(run, class.Department, func(c *types.ScrapeCounts) {
+			switch change {
+			case db.Added:
+				c.Added++
+			case db.Updated:
+				c.Updated++
+			default:
+				c.Unchanged++
+			}
+		})
+	}
+
+	err = <-digestErr
+	if storeErr != nil {
+		return report.Term, storeErr
+	}
+	if err != nil {
+		return report.Term, err
+	}
+
+	// Unmodified courses were left as they are, as were courses stored
+	// before the scrape was resumed.
+	for _, course := range report.Unmodified {
+		seen[classKey{report.Term, course.Department, course.Number}] = true
+		tally(run, course.Department, func(c *types.ScrapeCounts) { c.NotModified++ })
+	}
+	for _, course := range progress.Completed() {
+		seen[classKey{report.Term, course.Department, course.Number}] = true
+	}
+
+	for _, failure := range report.Failures {
+		run.Failures = append(run.Failures, types.ScrapeFailure{
+			URL:        failure.URL,
+			Department: failure.Department,
+			Error:      failure.Err.Error(),
+		})
+		tally(run, failure.Department, func(c *types.ScrapeCounts) { c.Failed++ })
+	}
+
+	// A filtered scrape can legitimately find nothing when the selected
+	// courses are no longer offered, in which case they're removed below.
+	if len(seen) == 0 && options.Filter.Empty() {
+		return report.Term, EmptyScrapeError
+	}
+
+	failed := make(map[string]bool)
+	for _, failure := range report.Failures {
+		if failure.Department == "" {
+			log.Warn("term scraped with failures, not removing any classes")
+			return report.Term, nil
+		}
+		failed[failure.Department] = true
+	}
+
+	log.Debug("removing classes no longer offered")
+	removed, err := removeUnseen(scrapeDB, report.Term, seen, failed, options.Filter)
+	for department, n := range removed {
+		tally(run, department, func(c *types.ScrapeCounts) { c.Removed += n })
+	}
+	return report.Term, err
+}
+
+// Remove every class in term in the store not in seen, returning how many
+// were removed from each department. Departments in skip and classes the
+// filter doesn't select are left alone.
+func removeUnseen(store db.Store, term types.Term, seen map[classKey]bool, skip map[string]bool, filter scrape.Filter) (map[string]int, error) {
+	removed := make(map[string]int)
+
+	classes, err := store.LookupAll(term, "basic")
+	if err != nil {
+		return removed, err
+	}
+
+	for _, class := range classes {
+		if skip[class.Department] || seen[classKey{term, class.Department, class.CourseNumber}] {
+			continue
+		}
+		if !filter.Course(scrape.CourseID{Department: class.Department, Number: class.CourseNumber}) {
+			continue
 		}
+
+		if err = store.Remove(term, class.Department, class.CourseNumber); err != nil {
+			return removed, err
+		}
+		removed[class.Department]++
+	}
+
+	return removed, nil
+}
+
+// Check a freshly scraped store is fit to replace the live one.
+func validateStaged(staging db.Store) error {
+	terms, err := staging.Terms()
+	if err != nil {
+		return err
 	}
 
-	log.Debug("finished populating database")
+	if len(terms) == 0 {
+		return EmptyScrapeError
+	}
 
 	return nil
 }
@@ -0,0 +1,229 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scheedule/coursestore/db"
+	"github.com/scheedule/coursestore/scrape"
+	"github.com/scheedule/coursestore/types"
+)
+
+var testTerm = types.Term{Year: 2016, Semester: "spring"}
+
+// Serve the scrape package's test documents, with SERVER replaced by the
+// server's URL.
+func testServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadFile(filepath.Join("..", "scrape", "testdata", filepath.FromSlash(r.URL.Path)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data = bytes.Replace(data, []byte("SERVER"), []byte(server.URL), -1)
+
+		w.Header().Set("Content-Type", "application/xml")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+
+	return server
+}
+
+func testScraper() *scrape.Scraper {
+	return scrape.New(scrape.Config{Retries: scrape.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+	}})
+}
+
+// Return a store holding a stale copy of each of the test term's courses, a
+// course no longer offered and a course in another department.
+func staleStore(t *testing.T) *db.Memory {
+	store := db.NewMemory()
+	for _, class := range []types.Class{
+		{Department: "CS", CourseNumber: 125, Name: "stale"},
+		{Department: "CS", CourseNumber: 225, Name: "stale"},
+		{Department: "CS", CourseNumber: 998, Name: "stale"},
+		{Department: "MATH", CourseNumber: 241, Name: "stale"},
+	} {
+		class.Year, class.Semester = testTerm.Year, testTerm.Semester
+		if err := store.Put(class); err != nil {
+			t.Fatal("Put returned error: ", err)
+		}
+	}
+
+	return store
+}
+
+// Return the name of every class store holds in the test term by course.
+func storedNames(t *testing.T, store db.Store) map[string]string {
+	classes, err := store.LookupAll(testTerm, "complete")
+	if err != nil {
+		t.Fatal("LookupAll returned error: ", err)
+	}
+
+	names := make(map[string]string)
+	for _, class := range classes {
+		names[fmt.Sprintf("%s %d", class.Department, class.CourseNumber)] = class.Name
+	}
+	return names
+}
+
+func TestPopulateDBFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter scrape.Filter
+		want   map[string]string
+	}{
+		{
+			name: "courses",
+			filter: scrape.Filter{Courses: []scrape.CourseID{
+				{Department: "CS", Number: 125},
+				{Department: "CS", Number: 998},
+			}},
+			want: map[string]string{
+				"CS 125":   "Intro to Computer Science",
+				"CS 225":   "stale",
+				"MATH 241": "stale",
+			},
+		},
+		{
+			// CS 999 fails to digest, so nothing is removed from CS.
+			name:   "department with failures",
+			filter: scrape.Filter{Departments: []string{"CS"}},
+			want: map[string]string{
+				"CS 125":   "Intro to Computer Science",
+				"CS 225":   "Data Structures",
+				"CS 998":   "stale",
+				"MATH 241": "stale",
+			},
+		},
+		{
+			name:   "dropped course",
+			filter: scrape.Filter{Courses: []scrape.CourseID{{Department: "CS", Number: 998}}},
+			want: map[string]string{
+				"CS 125":   "stale",
+				"CS 225":   "stale",
+				"MATH 241": "stale",
+			},
+		},
+	}
+
+	server := testServer(t)
+	defer server.Close()
+
+	for _, test := range tests {
+		store := staleStore(t)
+		_, err := PopulateDB(context.Background(), testScraper(), []string{server.URL + "/schedule/2016/spring.xml"},
+			store, PopulateOptions{Filter: test.filter})
+		if err != nil {
+			t.Errorf("%s: PopulateDB returned error: %v", test.name, err)
+			continue
+		}
+
+		got := storedNames(t, store)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: stored %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for course, name := range test.want {
+			if got[course] != name {
+				t.Errorf("%s: %s is named %q, want %q", test.name, course, got[course], name)
+			}
+		}
+	}
+}
+
+func TestPopulateDBThresholds(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   scrape.Filter
+		rejected bool
+		want     map[string]string
+	}{
+		{
+			// The stale classes have no credit hours, but aren't selected.
+			name:   "unselected classes failing checks",
+			filter: scrape.Filter{Courses: []scrape.CourseID{{Department: "CS", Number: 125}}},
+			want: map[string]string{
+				"CS 125":   "Intro to Computer Science",
+				"CS 225":   "stale",
+				"CS 998":   "stale",
+				"MATH 241": "stale",
+			},
+		},
+		{
+			// CS 998 is kept since CS 999 fails, and has no credit hours.
+			name:     "selected classes failing checks",
+			filter:   scrape.Filter{Departments: []string{"CS"}},
+			rejected: true,
+			want: map[string]string{
+				"CS 125":   "stale",
+				"CS 225":   "stale",
+				"CS 998":   "stale",
+				"MATH 241": "stale",
+			},
+		},
+	}
+
+	server := testServer(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "coursestore-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	for _, test := range tests {
+		store := staleStore(t)
+		_, err := PopulateDB(context.Background(), testScraper(), []string{server.URL + "/schedule/2016/spring.xml"},
+			store, PopulateOptions{
+				Checkpoint: scrape.NewCheckpoint(path, test.filter),
+				Filter:     test.filter,
+				Validate:   true,
+				Thresholds: scrape.DefaultThresholds,
+			})
+
+		if _, ok := err.(*scrape.ValidationError); ok != test.rejected {
+			t.Errorf("%s: PopulateDB returned %v, want rejected %v", test.name, err, test.rejected)
+		}
+
+		got := storedNames(t, store)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: stored %v, want %v", test.name, got, test.want)
+		}
+		for course, name := range test.want {
+			if got[course] != name {
+				t.Errorf("%s: %s is named %q, want %q", test.name, course, got[course], name)
+			}
+		}
+
+		// A rejected scrape must start over rather than resume.
+		_, statErr := os.Stat(path)
+		if !test.rejected {
+			if !os.IsNotExist(statErr) {
+				t.Errorf("%s: checkpoint left behind: %v", test.name, statErr)
+			}
+			continue
+		}
+		checkpoint, err := scrape.LoadCheckpoint(path, test.filter)
+		if err != nil {
+			t.Errorf("%s: LoadCheckpoint returned error: %v", test.name, err)
+		} else if !checkpoint.Empty() {
+			t.Errorf("%s: checkpoint of rejected scrape holds progress", test.name)
+		}
+		os.Remove(path)
+	}
+}
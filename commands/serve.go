@@ -8,7 +8,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/scheedule/coursestore/api"
-	"github.com/scheedule/coursestore/db"
 )
 
 var serveAPI *api.API
@@ -17,13 +16,12 @@ var serveAPI *api.API
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Serve Course Endpoint",
-	Long:  "Start serving course data via routes /lookup and /all",
+	Long:  "Start serving course data via routes /lookup, /terms and /buildings, and scrape history via /status/scrapes",
 	Run: func(cmd *cobra.Command, args []string) {
 		initializeConfig()
 
 		// Create DB Object
-		serveDB := db.New(dbHost, dbPort, database, collection)
-		err := serveDB.Init()
+		serveDB, err := openStore()
 		if err != nil {
 			log.Fatal("Failed to initialize database connection:", err)
 		}
@@ -43,12 +41,36 @@ var serveCmd = &cobra.Command{
 		// Specific Class
 		r.HandleFunc("/lookup/{department}/{number:[0-9]+}", serveAPI.HandleSingle)
 
+		// Terms in the store
+		r.HandleFunc("/terms", serveAPI.HandleTerms)
+
+		// Buildings meetings are held in, and the meetings in each
+		r.HandleFunc("/buildings", serveAPI.HandleBuildings)
+		r.HandleFunc("/buildings/{name}", serveAPI.HandleBuilding)
+
+		// Recent scrape runs
+		r.HandleFunc("/status/scrapes", serveAPI.HandleScrapes)
+
+		// The lookup routes for a specific term rather than the latest
+		term := r.PathPrefix("/terms/{year:[0-9]+}/{semester}").Subrouter()
+		term.HandleFunc("/lookup", serveAPI.HandleAll)
+		term.HandleFunc("/lookup/{department}", serveAPI.HandleDepartment)
+		term.HandleFunc("/lookup/{department}/{number:[0-9]+}", serveAPI.HandleSingle)
+		term.HandleFunc("/buildings", serveAPI.HandleBuildings)
+		term.HandleFunc("/buildings/{name}", serveAPI.HandleBuilding)
+
 		log.Info("Serving on port:", servePort)
 		http.ListenAndServe(":"+servePort, r)
 	},
 }
 
 func init() {
+	serveCmd.Flags().StringVarP(
+		&storeType, "store", "", "mongo", "Store backend to use: mongo, bolt or memory.")
+
+	serveCmd.Flags().StringVarP(
+		&storePath, "store_path", "", "coursestore.db", "Path to the bolt store file.")
+
 	serveCmd.Flags().StringVarP(
 		&dbHost, "db_host", "", "localhost", "Hostname of DB to insert into.")
 
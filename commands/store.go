@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/scheedule/coursestore/db"
+)
+
+// Open the store selected by the command line flags.
+func openStore() (db.Store, error) {
+	switch storeType {
+	case "mongo":
+		mongo := db.New(dbHost, dbPort, database, collection)
+		if err := mongo.Init(); err != nil {
+			return nil, err
+		}
+		return mongo, nil
+
+	case "memory":
+		return db.NewMemory(), nil
+
+	case "bolt":
+		file := db.NewBolt(storePath)
+		if err := file.Init(); err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+
+	return nil, fmt.Errorf("unknown store %q", storeType)
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/scheedule/coursestore/db"
+	"github.com/scheedule/coursestore/scrape"
+	"github.com/scheedule/coursestore/types"
+)
+
+var scheduleURL, discover string
+
+var termsCmd = &cobra.Command{
+	Use:   "terms",
+	Short: "List terms available to scrape",
+	Long:  "List every term the course API offers along with its term URL",
+	Run: func(cmd *cobra.Command, args []string) {
+		initializeConfig()
+
+		ctx, cancel := commandContext(0)
+		defer cancel()
+
+		terms, err := newScraper(nil).ListTerms(ctx, scheduleURL)
+		if err != nil {
+			log.Fatal("Failed to list terms: ", err)
+		}
+
+		for _, term := range terms {
+			fmt.Printf("%d\t%s\t%s\n", term.Year, term.Semester, term.URL)
+		}
+	},
+}
+
+func init() {
+	termsCmd.Flags().StringVarP(
+		&scheduleURL, "schedule_url", "", scrape.ScheduleURL, "URL to schedule XML.")
+
+	termsCmd.Flags().StringVarP(
+		&userAgent, "user_agent", "", "", "User-Agent header to send to the course API.")
+}
+
+// Find the URLs of the terms to scrape from the schedule. In "latest" mode
+// that is the newest term offered; in "unscraped" mode every term offered
+// that the store holds no classes for.
+func discoverTerms(ctx context.Context, scraper *scrape.Scraper, mode string, store db.Store) ([]string, error) {
+	available, err := scraper.ListTerms(ctx, scheduleURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case "latest":
+		if len(available) == 0 {
+			return nil, nil
+		}
+		return []string{available[len(available)-1].URL}, nil
+
+	case "unscraped":
+		stored, err := store.Terms()
+		if err != nil {
+			return nil, err
+		}
+
+		scraped := make(map[types.Term]bool)
+		for _, term := range stored {
+			scraped[term] = true
+		}
+
+		var urls []string
+		for _, term := range available {
+			if !scraped[term.Term] {
+				urls = append(urls, term.URL)
+			}
+		}
+		return urls, nil
+	}
+
+	return nil, fmt.Errorf("unknown discover mode %q", mode)
+}
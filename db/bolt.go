@@ -0,0 +1,568 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// Names of the top level buckets holding each generation of classes. Each
+// holds one bucket per term, which holds one bucket per department.
+var (
+	classesBucket  = []byte("classes")
+	stagingBucket  = []byte("classes_staging")
+	previousBucket = []byte("classes_previous")
+	rollbackBucket = []byte("classes_rollback")
+)
+
+// Name of the bucket holding scrape runs, keyed by sequence number.
+var runsBucket = []byte("runs")
+
+// Name of the bucket holding the validators of the documents the live
+// classes were scraped from, keyed by URL.
+var validatorsBucket = []byte("validators")
+
+// Bolt is a Store kept in a single BoltDB file, letting coursestore run as a
+// single binary without a database server. Classes are stored in a bucket
+// per term and department keyed by course number.
+type Bolt struct {
+	db     *bolt.DB
+	path   string
+	bucket []byte
+
+	// Set on stores sharing the file of another Bolt, like the one returned
+	// by Staging, which must not close it.
+	shared bool
+}
+
+// Ensure the BoltDB backend satisfies Generational.
+var _ Generational = (*Bolt)(nil)
+
+// Construct a new Bolt store backed by the file at path.
+func NewBolt(path string) *Bolt {
+	return &Bolt{path: path, bucket: classesBucket}
+}
+
+// Open the database file, creating it if needed. An error will be returned
+// if the file can't be locked within five seconds.
+func (b *Bolt) Init() error {
+	db, err := bolt.Open(b.path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Error("failed to open bolt database: ", err)
+		return InternalError
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{classesBucket, stagingBucket, runsBucket, validatorsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		log.Error("failed to create classes buckets: ", err)
+		return InternalError
+	}
+
+	b.db = db
+	return nil
+}
+
+// Remove every Class from the store. Purging the live generation drops the
+// saved validators too.
+func (b *Bolt) Purge() error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if bytes.Equal(b.bucket, classesBucket) {
+			if err := resetBucket(tx, validatorsBucket); err != nil {
+				return err
+			}
+		}
+		return resetBucket(tx, b.bucket)
+	})
+	if err != nil {
+		log.Error("failed to purge bolt database: ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Close the database file.
+func (b *Bolt) Close() {
+	if !b.shared {
+		b.db.Close()
+	}
+}
+
+// Return a Bolt store on the staging bucket of the same file, reset to a copy
+// of the live bucket. Closing it leaves the file open.
+func (b *Bolt) Staging() (Store, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := resetBucket(tx, stagingBucket); err != nil {
+			return err
+		}
+		return copyBucket(tx.Bucket(stagingBucket), tx.Bucket(classesBucket))
+	})
+	if err != nil {
+		log.Error("failed to copy live generation to staging: ", err)
+		return nil, InternalError
+	}
+
+	return &Bolt{db: b.db, path: b.path, bucket: stagingBucket, shared: true}, nil
+}
+
+// Return a view of the staging bucket as it was left, or NothingStaged if it
+// is empty.
+func (b *Bolt) ResumeStaging() (Store, error) {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		staging := tx.Bucket(stagingBucket)
+		if staging == nil {
+			return NothingStaged
+		}
+		if k, _ := staging.Cursor().First(); k == nil {
+			return NothingStaged
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bolt{db: b.db, path: b.path, bucket: stagingBucket, shared: true}, nil
+}
+
+// Make the staging generation live, keeping the live one for Rollback. The
+// whole exchange happens in one transaction so readers see either the old
+// or the new generation.
+func (b *Bolt) Swap() error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(stagingBucket).Cursor().First(); k == nil {
+			return NothingStaged
+		}
+		if err := moveBucket(tx, classesBucket, previousBucket); err != nil {
+			return err
+		}
+		return moveBucket(tx, stagingBucket, classesBucket)
+	})
+	if err == NothingStaged {
+		return err
+	}
+	if err != nil {
+		log.Error("failed to swap staging generation: ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Exchange the live and previous generations in one transaction, dropping
+// the saved validators.
+func (b *Bolt) Rollback() error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(previousBucket) == nil {
+			return NoPreviousGeneration
+		}
+		if err := resetBucket(tx, validatorsBucket); err != nil {
+			return err
+		}
+		if err := moveBucket(tx, classesBucket, rollbackBucket); err != nil {
+			return err
+		}
+		if err := moveBucket(tx, previousBucket, classesBucket); err != nil {
+			return err
+		}
+		if err := moveBucket(tx, rollbackBucket, previousBucket); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(rollbackBucket)
+	})
+	if err == NoPreviousGeneration {
+		return err
+	}
+	if err != nil {
+		log.Error("failed to roll back generation: ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Put Class into the store, replacing any Class with the same term,
+// department and course number.
+func (b *Bolt) Put(entry types.Class) error {
+	if entry.ID == "" {
+		entry.ID = bson.NewObjectId()
+	}
+
+	data, err := bson.Marshal(entry)
+	if err != nil {
+		log.Error("failed to marshal class")
+		return InternalError
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		department, err := b.createDepartmentBucket(tx, entry)
+		if err != nil {
+			return err
+		}
+		return department.Put(courseKey(entry.CourseNumber), data)
+	})
+	if err != nil {
+		log.Error("failed to insert class: ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Insert or replace the Class with the same term, department and course
+// number, keeping the id of a replaced Class.
+func (b *Bolt) Upsert(entry types.Class) (Change, error) {
+	change := Unchanged
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		department, err := b.createDepartmentBucket(tx, entry)
+		if err != nil {
+			return err
+		}
+
+		key := courseKey(entry.CourseNumber)
+		existing := department.Get(key)
+		if existing == nil {
+			change = Added
+			entry.ID = bson.NewObjectId()
+		} else {
+			var stored types.Class
+			if err = bson.Unmarshal(existing, &stored); err != nil {
+				return err
+			}
+			entry.ID = stored.ID
+		}
+
+		data, err := bson.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		if existing != nil && bytes.Equal(existing, data) {
+			return nil
+		}
+		if existing != nil {
+			change = Updated
+		}
+		return department.Put(key, data)
+	})
+	if err != nil {
+		log.Error("failed to upsert class: ", err)
+		return Unchanged, InternalError
+	}
+
+	return change, nil
+}
+
+// Remove the Class with the given term, department and course number.
+func (b *Bolt) Remove(term types.Term, department string, number int) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := b.departmentBucket(tx, term, department)
+		if bucket == nil || bucket.Get(courseKey(number)) == nil {
+			return ClassNotFound
+		}
+		return bucket.Delete(courseKey(number))
+	})
+	if err == ClassNotFound {
+		return err
+	}
+	if err != nil {
+		log.Error("failed to remove class: ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Lookup Class in the store.
+func (b *Bolt) LookupSingle(term types.Term, department, number, detail string) (types.Class, error) {
+	proj := DetailLevels[detail+"_single"]
+
+	courseNum, _ := strconv.Atoi(number)
+
+	var data []byte
+	b.db.View(func(tx *bolt.Tx) error {
+		bucket := b.departmentBucket(tx, term, department)
+		if bucket != nil {
+			data = bucket.Get(courseKey(courseNum))
+		}
+		return nil
+	})
+
+	if data == nil {
+		log.Warn("failed to find class in bolt database")
+		return types.Class{}, ClassNotFound
+	}
+
+	return decodeClass(data, proj)
+}
+
+// Lookup all Classes in a department in a term in the store.
+func (b *Bolt) LookupDepartment(term types.Term, department, detail string) ([]types.Class, error) {
+	proj := DetailLevels[detail+"_department"]
+
+	result := make([]types.Class, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := b.departmentBucket(tx, term, department)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(_, data []byte) error {
+			class, err := decodeClass(data, proj)
+			result = append(result, class)
+			return err
+		})
+	})
+	if err != nil {
+		log.Error("failed to collect all entries in the department")
+		return nil, InternalError
+	}
+
+	return result, nil
+}
+
+// Get All Classes in a term from the store.
+func (b *Bolt) LookupAll(term types.Term, detail string) ([]types.Class, error) {
+	proj := DetailLevels[detail+"_all"]
+
+	result := make([]types.Class, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		termBucket := tx.Bucket(b.bucket).Bucket(termKey(term))
+		if termBucket == nil {
+			return nil
+		}
+
+		return termBucket.ForEach(func(name, _ []byte) error {
+			return termBucket.Bucket(name).ForEach(func(_, data []byte) error {
+				class, err := decodeClass(data, proj)
+				result = append(result, class)
+				return err
+			})
+		})
+	})
+	if err != nil {
+		log.Error("failed to collect all entries in the bolt database")
+		return nil, InternalError
+	}
+
+	return result, nil
+}
+
+// List every term with classes in the store, oldest first.
+func (b *Bolt) Terms() ([]types.Term, error) {
+	terms := make([]types.Term, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).ForEach(func(name, _ []byte) error {
+			parts := strings.SplitN(string(name), "/", 2)
+			year, err := strconv.Atoi(parts[0])
+			if err != nil || len(parts) != 2 {
+				return fmt.Errorf("malformed term bucket %q", name)
+			}
+
+			terms = append(terms, types.Term{Year: year, Semester: parts[1]})
+			return nil
+		})
+	})
+	if err != nil {
+		log.Error("failed to collect terms in the bolt database: ", err)
+		return nil, InternalError
+	}
+	sortTerms(terms)
+
+	return terms, nil
+}
+
+// Return the bucket holding a department's classes in a term, or nil if
+// there is none.
+func (b *Bolt) departmentBucket(tx *bolt.Tx, term types.Term, department string) *bolt.Bucket {
+	termBucket := tx.Bucket(b.bucket).Bucket(termKey(term))
+	if termBucket == nil {
+		return nil
+	}
+
+	return termBucket.Bucket([]byte(department))
+}
+
+// Return the bucket to hold class, creating it and its term bucket if
+// needed.
+func (b *Bolt) createDepartmentBucket(tx *bolt.Tx, class types.Class) (*bolt.Bucket, error) {
+	termBucket, err := tx.Bucket(b.bucket).CreateBucketIfNotExists(termKey(class.Term()))
+	if err != nil {
+		return nil, err
+	}
+
+	return termBucket.CreateBucketIfNotExists([]byte(class.Department))
+}
+
+// Replace the top level bucket name with an empty one.
+func resetBucket(tx *bolt.Tx, name []byte) error {
+	if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	_, err := tx.CreateBucket(name)
+	return err
+}
+
+// Replace the top level bucket to with the contents of from, leaving from
+// empty.
+func moveBucket(tx *bolt.Tx, from, to []byte) error {
+	if err := resetBucket(tx, to); err != nil {
+		return err
+	}
+	if err := copyBucket(tx.Bucket(to), tx.Bucket(from)); err != nil {
+		return err
+	}
+	return resetBucket(tx, from)
+}
+
+// Recursively copy every key and nested bucket of src into dst.
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(nested, src.Bucket(k))
+	})
+}
+
+// Key terms by year and semester, like "2016/spring".
+func termKey(term types.Term) []byte {
+	return []byte(fmt.Sprintf("%d/%s", term.Year, term.Semester))
+}
+
+// Key classes by zero padded course number so they iterate in order.
+func courseKey(number int) []byte {
+	return []byte(fmt.Sprintf("%04d", number))
+}
+
+// Decode a stored Class and apply the projection proj to it.
+func decodeClass(data []byte, proj interface{}) (types.Class, error) {
+	var class types.Class
+	if err := bson.Unmarshal(data, &class); err != nil {
+		log.Error("failed to unmarshal class: ", err)
+		return class, InternalError
+	}
+
+	return projectOne(class, proj)
+}
+
+// Record a scrape run under the next sequence number.
+func (b *Bolt) PutRun(run types.ScrapeRun) error {
+	data, err := bson.Marshal(run)
+	if err != nil {
+		log.Error("failed to marshal scrape run: ", err)
+		return InternalError
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(runsBucket)
+		seq, err := runs.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return runs.Put(key, data)
+	})
+	if err != nil {
+		log.Error("failed to put scrape run: ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Return the latest scrape runs, newest first.
+func (b *Bolt) Runs(limit int) ([]types.ScrapeRun, error) {
+	var runs []types.ScrapeRun
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		for k, v := c.Last(); k != nil && (limit <= 0 || len(runs) < limit); k, v = c.Prev() {
+			var run types.ScrapeRun
+			if err := bson.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("failed to read scrape runs: ", err)
+		return nil, InternalError
+	}
+
+	return runs, nil
+}
+
+// Return the validators saved by the given parser version.
+func (b *Bolt) Validators(version int) (map[string]types.DocumentValidators, error) {
+	all := make(map[string]types.DocumentValidators)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(validatorsBucket).ForEach(func(k, v []byte) error {
+			var stored storedValidators
+			if err := bson.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			if stored.Version == version {
+				all[string(k)] = stored.DocumentValidators
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Error("failed to read validators: ", err)
+		return nil, InternalError
+	}
+
+	return all, nil
+}
+
+// Replace the saved validators with all in one transaction.
+func (b *Bolt) PutValidators(version int, all map[string]types.DocumentValidators) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := resetBucket(tx, validatorsBucket); err != nil {
+			return err
+		}
+
+		bucket := tx.Bucket(validatorsBucket)
+		for url, v := range all {
+			data, err := bson.Marshal(storedValidators{URL: url, Version: version, DocumentValidators: v})
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put([]byte(url), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("failed to put validators: ", err)
+		return InternalError
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Return a Bolt store in a fresh temporary directory along with a function
+// removing it.
+func getBolt(t *testing.T) (*Bolt, func()) {
+	dir, err := ioutil.TempDir("", "coursestore")
+	if err != nil {
+		t.Fatal("failed to create temporary directory: ", err)
+	}
+
+	b := NewBolt(filepath.Join(dir, "test.db"))
+	if err = b.Init(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal("Failed to initialize bolt store: ", err)
+	}
+
+	return b, func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	b, cleanup := getBolt(t)
+	defer cleanup()
+
+	testStore(t, b)
+	testUpsert(t, b)
+	testRunLog(t, b)
+}
+
+func TestBoltGenerational(t *testing.T) {
+	b, cleanup := getBolt(t)
+	defer cleanup()
+
+	if err := b.Swap(); err != NothingStaged {
+		t.Errorf("Swap of empty staging returned %v, want %v", err, NothingStaged)
+	}
+
+	testGenerational(t, b)
+	testValidatorLog(t, b)
+}
+
+func TestBoltPersists(t *testing.T) {
+	b, cleanup := getBolt(t)
+	defer cleanup()
+
+	if err := b.Put(sampleClass); err != nil {
+		t.Fatal("Put returned error: ", err)
+	}
+	b.Close()
+
+	if err := b.Init(); err != nil {
+		t.Fatal("Failed to reopen bolt store: ", err)
+	}
+
+	class, err := b.LookupSingle(sampleTerm, "CS", "125", "complete")
+	if err != nil {
+		t.Fatal("LookupSingle returned error: ", err)
+	}
+	if class.Name != sampleClass.Name || len(class.Sections) != 1 {
+		t.Error("reopened store returned inaccurate class: ", class)
+	}
+}
@@ -1,10 +1,12 @@
 // Package db handles all course storing and retrieval from the database.
 // This package provides an abstraction to allow users to interact with the
 // database with the Class struct type and restrict usage to looking up,
-// putting, and purging.
+// putting, and purging. Backends implement the Store interface; DB is the
+// MongoDB backend.
 package db
 
 import (
+	"bytes"
 	"errors"
 	"strconv"
 	"time"
@@ -24,31 +26,67 @@ var (
 	// database without error
 	InternalError error = errors.New("Internal Database Error")
 
+	// NothingStaged is returned when swapping in a staging generation that
+	// was never written.
+	NothingStaged error = errors.New("No Staged Generation")
+
+	// NoPreviousGeneration is returned when rolling back a store that has
+	// never been swapped.
+	NoPreviousGeneration error = errors.New("No Previous Generation")
+
 	// Detail levels
 	DetailLevels = map[string]interface{}{
 		"basic_single":     nil,
 		"basic_department": nil,
 		"basic_all": bson.M{
+			"year":                    "1",
+			"semester":                "1",
 			"department":              "1",
 			"course_number":           "1",
 			"name":                    "1",
+			"credit_hours":            "1",
+			"min_credit_hours":        "1",
+			"max_credit_hours":        "1",
+			"gen_eds":                 "1",
 			"sections":                "1",
 			"sections.crn":            "1",
 			"sections.code":           "1",
+			"sections.credit_hours":   "1",
+			"sections.start_date":     "1",
+			"sections.end_date":       "1",
+			"sections.part_of_term":   "1",
 			"sections.meetings":       "1",
 			"sections.meetings.type":  "1",
 			"sections.meetings.start": "1",
 			"sections.meetings.end":   "1",
 			"sections.meetings.days":  "1",
+
+			"sections.min_credit_hours":       "1",
+			"sections.max_credit_hours":       "1",
+			"sections.meetings.start_minutes": "1",
+			"sections.meetings.end_minutes":   "1",
+			"sections.meetings.weekdays":      "1",
 		},
 		"complete_single":     nil,
 		"complete_department": nil,
 		"complete_all":        nil,
+
+		// Only what the buildings routes list of each meeting.
+		"buildings_all": bson.M{
+			"year":              "1",
+			"semester":          "1",
+			"department":        "1",
+			"course_number":     "1",
+			"name":              "1",
+			"sections.crn":      "1",
+			"sections.code":     "1",
+			"sections.meetings": "1",
+		},
 	}
 )
 
-// Main primitive to hold db connection and attributes. Users will obtain
-// and make requests with the DB type.
+// Main primitive to hold a MongoDB connection and attributes. DB implements
+// Store on top of a single collection.
 type DB struct {
 	session        *mgo.Session
 	collection     *mgo.Collection
@@ -88,11 +126,196 @@ func (db *DB) Init() error {
 	return nil
 }
 
-// Drop the specified collection from the database.
-func (db *DB) Purge() error {
-	err := db.collection.DropCollection()
+// Staging returns a DB on the staging collection, named after the live
+// collection with a "_staging" suffix and filled with a copy of it. The copy
+// is made by the server in one aggregation and never touches the live
+// documents; a scrape then only rewrites the staged documents that changed.
+// It uses its own copy of the session and should be closed by the caller.
+func (db *DB) Staging() (Store, error) {
+	staging := &DB{
+		session:        db.session.Copy(),
+		server:         db.server,
+		dbName:         db.dbName,
+		collectionName: db.collectionName + "_staging",
+	}
+	staging.collection = staging.session.DB(db.dbName).C(staging.collectionName)
+
+	live, err := db.hasCollection(db.collectionName)
+	if err == nil && live {
+		err = db.copyCollection(db.collectionName, staging.collectionName)
+	} else if err == nil {
+		err = staging.Purge()
+	}
+	if err != nil {
+		staging.Close()
+		return nil, err
+	}
+
+	return staging, nil
+}
+
+// ResumeStaging returns a DB on the staging collection as it was left, or
+// NothingStaged if there is no staging collection. Like Staging, it should
+// be closed by the caller.
+func (db *DB) ResumeStaging() (Store, error) {
+	name := db.collectionName + "_staging"
+
+	exists, err := db.hasCollection(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, NothingStaged
+	}
+
+	staging := &DB{
+		session:        db.session.Copy(),
+		server:         db.server,
+		dbName:         db.dbName,
+		collectionName: name,
+	}
+	staging.collection = staging.session.DB(db.dbName).C(name)
+
+	return staging, nil
+}
+
+// Swap the staging collection into place. The live collection, if any, is
+// first copied to the "_previous" collection for Rollback, then the staging
+// collection is renamed over it. Each step is atomic, but the two together
+// are not: if the rename fails, the live and staging collections are left as
+// they were and Swap can be retried, though the generation "_previous" held
+// before is already replaced by a copy of the live one.
+func (db *DB) Swap() error {
+	staging := db.collectionName + "_staging"
+	previous := db.collectionName + "_previous"
+
+	exists, err := db.hasCollection(staging)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return NothingStaged
+	}
+
+	live, err := db.hasCollection(db.collectionName)
 	if err != nil {
-		log.Error("failed to purge database")
+		return err
+	}
+	if live {
+		if err = db.copyCollection(db.collectionName, previous); err != nil {
+			return err
+		}
+	}
+
+	return db.renameCollection(staging, db.collectionName)
+}
+
+// Rollback exchanges the live collection with the "_previous" collection,
+// so rolling back twice restores the newest generation again. The live
+// collection is copied to "_rollback", "_previous" is renamed over the live
+// collection, then "_rollback" is renamed to "_previous". Each step is atomic
+// and the live collection only changes in the second. A rollback interrupted
+// after it is finished by the next call to Rollback, which leaves "_rollback"
+// behind and "_previous" missing. The saved validators are dropped before
+// anything else.
+func (db *DB) Rollback() error {
+	previous := db.collectionName + "_previous"
+	rollback := db.collectionName + "_rollback"
+
+	exists, err := db.hasCollection(previous)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		interrupted, err := db.hasCollection(rollback)
+		if err != nil {
+			return err
+		}
+		if !interrupted {
+			return NoPreviousGeneration
+		}
+
+		log.Warn("finishing interrupted rollback")
+		return db.renameCollection(rollback, previous)
+	}
+
+	if err = db.dropCollection(db.collectionName + "_validators"); err != nil {
+		return err
+	}
+	if err = db.copyCollection(db.collectionName, rollback); err != nil {
+		return err
+	}
+	if err = db.renameCollection(previous, db.collectionName); err != nil {
+		return err
+	}
+
+	return db.renameCollection(rollback, previous)
+}
+
+// Return true if the named collection exists in the database.
+func (db *DB) hasCollection(name string) (bool, error) {
+	names, err := db.session.DB(db.dbName).CollectionNames()
+	if err != nil {
+		log.Error("failed to list collections")
+		return false, InternalError
+	}
+
+	for _, n := range names {
+		if n == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Replace the collection to with a copy of the collection from.
+func (db *DB) copyCollection(from, to string) error {
+	var out []bson.M
+	err := db.session.DB(db.dbName).C(from).Pipe([]bson.M{{"$out": to}}).All(&out)
+	if err != nil {
+		log.Error("failed to copy collection ", from, " to ", to, ": ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Atomically rename the collection from to to, dropping any existing
+// collection named to.
+func (db *DB) renameCollection(from, to string) error {
+	err := db.session.Run(bson.D{
+		{Name: "renameCollection", Value: db.dbName + "." + from},
+		{Name: "to", Value: db.dbName + "." + to},
+		{Name: "dropTarget", Value: true},
+	}, nil)
+	if err != nil {
+		log.Error("failed to rename collection ", from, " to ", to, ": ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Drop the specified collection from the database, along with its
+// "_validators" collection. Dropping a collection that doesn't exist yet is
+// not an error.
+func (db *DB) Purge() error {
+	if err := db.dropCollection(db.collectionName + "_validators"); err != nil {
+		return err
+	}
+	return db.dropCollection(db.collectionName)
+}
+
+// Drop the named collection if it exists.
+func (db *DB) dropCollection(name string) error {
+	exists, err := db.hasCollection(name)
+	if err != nil || !exists {
+		return err
+	}
+
+	if err = db.session.DB(db.dbName).C(name).DropCollection(); err != nil {
+		log.Error("failed to drop collection ", name, ": ", err)
 		return InternalError
 	}
 
@@ -115,8 +338,152 @@ func (db *DB) Put(entry types.Class) error {
 	return nil
 }
 
+// Record a scrape run in the "_runs" collection beside the live one.
+func (db *DB) PutRun(run types.ScrapeRun) error {
+	err := db.runs().Insert(run)
+	if err != nil {
+		log.Error("failed to insert scrape run: ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Return the latest scrape runs, newest first.
+func (db *DB) Runs(limit int) ([]types.ScrapeRun, error) {
+	query := db.runs().Find(nil).Sort("-start")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var runs []types.ScrapeRun
+	if err := query.All(&runs); err != nil {
+		log.Error("failed to find scrape runs: ", err)
+		return nil, InternalError
+	}
+
+	return runs, nil
+}
+
+// Return the collection scrape runs are kept in.
+func (db *DB) runs() *mgo.Collection {
+	return db.session.DB(db.dbName).C(db.collectionName + "_runs")
+}
+
+// Return the validators saved by the given parser version in the
+// "_validators" collection beside the live one.
+func (db *DB) Validators(version int) (map[string]types.DocumentValidators, error) {
+	var stored []storedValidators
+	err := db.session.DB(db.dbName).C(db.collectionName + "_validators").
+		Find(bson.M{"version": version}).All(&stored)
+	if err != nil {
+		log.Error("failed to find validators: ", err)
+		return nil, InternalError
+	}
+
+	all := make(map[string]types.DocumentValidators, len(stored))
+	for _, v := range stored {
+		all[v.URL] = v.DocumentValidators
+	}
+	return all, nil
+}
+
+// Replace the saved validators with all. The old validators are dropped
+// before the new ones are inserted, so if inserting fails some documents are
+// left without validators and are fetched in full by the next scrape.
+func (db *DB) PutValidators(version int, all map[string]types.DocumentValidators) error {
+	name := db.collectionName + "_validators"
+	if err := db.dropCollection(name); err != nil {
+		return err
+	}
+	if len(all) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(all))
+	for url, v := range all {
+		docs = append(docs, storedValidators{URL: url, Version: version, DocumentValidators: v})
+	}
+
+	if err := db.session.DB(db.dbName).C(name).Insert(docs...); err != nil {
+		log.Error("failed to insert validators: ", err)
+		return InternalError
+	}
+
+	return nil
+}
+
+// Insert or update the Class with the same term, department and course
+// number. An existing document is left alone if it already matches the class,
+// and replaced whole otherwise, keeping its id, so fields no longer in Class
+// are dropped.
+func (db *DB) Upsert(entry types.Class) (Change, error) {
+	var existing bson.Raw
+	err := db.collection.Find(bson.M{
+		"year":          entry.Year,
+		"semester":      entry.Semester,
+		"department":    entry.Department,
+		"course_number": entry.CourseNumber,
+	}).One(&existing)
+	if err == mgo.ErrNotFound {
+		entry.ID = bson.NewObjectId()
+		if err = db.collection.Insert(entry); err != nil {
+			log.Error("failed to insert class")
+			return Unchanged, InternalError
+		}
+		return Added, nil
+	}
+	if err != nil {
+		log.Error("failed to find class to upsert")
+		return Unchanged, InternalError
+	}
+
+	var stored struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	if err = existing.Unmarshal(&stored); err != nil {
+		log.Error("failed to unmarshal class")
+		return Unchanged, InternalError
+	}
+	entry.ID = stored.ID
+
+	data, err := bson.Marshal(entry)
+	if err != nil {
+		log.Error("failed to marshal class")
+		return Unchanged, InternalError
+	}
+	if bytes.Equal(existing.Data, data) {
+		return Unchanged, nil
+	}
+
+	if err = db.collection.UpdateId(entry.ID, entry); err != nil {
+		log.Error("failed to replace class")
+		return Unchanged, InternalError
+	}
+	return Updated, nil
+}
+
+// Remove the Class with the given term, department and course number.
+func (db *DB) Remove(term types.Term, department string, number int) error {
+	err := db.collection.Remove(bson.M{
+		"year":          term.Year,
+		"semester":      term.Semester,
+		"department":    department,
+		"course_number": number,
+	})
+	if err == mgo.ErrNotFound {
+		return ClassNotFound
+	}
+	if err != nil {
+		log.Error("failed to remove class")
+		return InternalError
+	}
+
+	return nil
+}
+
 // Lookup Class in the database.
-func (db *DB) LookupSingle(department, number, detail string) (types.Class, error) {
+func (db *DB) LookupSingle(term types.Term, department, number, detail string) (types.Class, error) {
 
 	proj := DetailLevels[detail+"_single"]
 
@@ -124,6 +491,8 @@ func (db *DB) LookupSingle(department, number, detail string) (types.Class, erro
 
 	var result types.Class
 	err := db.collection.Find(bson.M{
+		"year":          term.Year,
+		"semester":      term.Semester,
 		"department":    department,
 		"course_number": courseNum,
 	}).Select(proj).One(&result)
@@ -136,12 +505,15 @@ func (db *DB) LookupSingle(department, number, detail string) (types.Class, erro
 	return result, nil
 }
 
-func (db *DB) LookupDepartment(department, detail string) ([]types.Class, error) {
+// Lookup all Classes in a department in the database.
+func (db *DB) LookupDepartment(term types.Term, department, detail string) ([]types.Class, error) {
 
 	proj := DetailLevels[detail+"_department"]
 
 	var result []types.Class
 	err := db.collection.Find(bson.M{
+		"year":       term.Year,
+		"semester":   term.Semester,
 		"department": department,
 	}).Select(proj).All(&result)
 	if err != nil {
@@ -151,16 +523,41 @@ func (db *DB) LookupDepartment(department, detail string) ([]types.Class, error)
 	return result, nil
 }
 
-// Get All Class Names from the database.
-func (db *DB) LookupAll(detail string) ([]types.Class, error) {
+// Get All Class Names in a term from the database.
+func (db *DB) LookupAll(term types.Term, detail string) ([]types.Class, error) {
 
 	proj := DetailLevels[detail+"_all"]
 
 	var result []types.Class
-	err := db.collection.Find(nil).Select(proj).All(&result)
+	err := db.collection.Find(bson.M{
+		"year":     term.Year,
+		"semester": term.Semester,
+	}).Select(proj).All(&result)
 	if err != nil {
 		log.Error("failed to collect all entries in the collection")
 		return nil, InternalError
 	}
 	return result, nil
 }
+
+// List every term with classes in the database, oldest first.
+func (db *DB) Terms() ([]types.Term, error) {
+	var groups []struct {
+		Term types.Term `bson:"_id"`
+	}
+	err := db.collection.Pipe([]bson.M{
+		{"$group": bson.M{"_id": bson.M{"year": "$year", "semester": "$semester"}}},
+	}).All(&groups)
+	if err != nil {
+		log.Error("failed to collect terms in the collection")
+		return nil, InternalError
+	}
+
+	terms := make([]types.Term, 0, len(groups))
+	for _, group := range groups {
+		terms = append(terms, group.Term)
+	}
+	sortTerms(terms)
+
+	return terms, nil
+}
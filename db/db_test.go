@@ -2,114 +2,397 @@ package db
 
 import (
 	"os"
+	"reflect"
 	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
 
 	"github.com/scheedule/coursestore/types"
 )
 
-func TestNewDB(t *testing.T) {
-	myDB := New(os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"), os.Getenv("DB_COLLECTION"))
-	if myDB == nil {
-		t.Fail()
+// Return a connected MongoDB store, skipping the test when no database is
+// configured through the environment.
+func getDB(t *testing.T) *DB {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST not set, skipping MongoDB test")
 	}
-}
 
-func TestInit(t *testing.T) {
 	myDB := New(os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"), os.Getenv("DB_COLLECTION"))
-	err := myDB.Init()
-	if err != nil {
-		t.Error("Failed to initialize DB:", err)
-	}
-	if myDB.session == nil {
-		t.Error("Session is nil")
+	if err := myDB.Init(); err != nil {
+		t.Fatal("Failed to initialize DB:", err)
 	}
-	if myDB.collection == nil {
-		t.Error("Collection is nil")
-	}
-}
-
-func getDB() *DB {
-	myDB := New(os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"), os.Getenv("DB_COLLECTION"))
-	_ = myDB.Init()
 	return myDB
 }
 
+var sampleTerm = types.Term{Year: 2016, Semester: "spring"}
+
 var sampleClass = types.Class{
+	Year:         2016,
+	Semester:     "spring",
 	Department:   "CS",
-	CourseNumber: "125",
+	CourseNumber: 125,
+	Name:         "Intro to Computer Science",
+	Description:  "Basic concepts in computing.",
+	Sections: []types.Section{
+		{
+			CRN:  31152,
+			Code: "AL1",
+			Meetings: []types.Meeting{
+				{
+					Type:     types.CourseType{Name: "Lecture", Code: "LEC"},
+					Start:    "09:00 AM",
+					End:      "09:50 AM",
+					Days:     "MWF",
+					Building: "Siebel Center",
+
+					StartMinutes: 540,
+					EndMinutes:   590,
+				},
+			},
+		},
+	},
 }
 
-func TestPurge(t *testing.T) {
-	myDB := getDB()
-	myDB.Put(sampleClass)
-	myDB.Purge()
-	classes, err := myDB.GetAll(nil)
+// Run the behavior every Store is expected to share against s.
+func testStore(t *testing.T, s Store) {
+	if err := s.Purge(); err != nil {
+		t.Fatal("Purge returned error: ", err)
+	}
+
+	if err := s.Put(sampleClass); err != nil {
+		t.Fatal("Put returned error: ", err)
+	}
+	for i := 0; i < 9; i++ {
+		err := s.Put(types.Class{Year: 2016, Semester: "spring", Department: "MATH", CourseNumber: 200 + i})
+		if err != nil {
+			t.Fatal("Put returned error: ", err)
+		}
+	}
+
+	class, err := s.LookupSingle(sampleTerm, "CS", "125", "complete")
 	if err != nil {
-		t.Error(err)
+		t.Fatal("LookupSingle returned error: ", err)
+	}
+	if class.Department != "CS" || class.CourseNumber != 125 || class.Description == "" {
+		t.Error("LookupSingle result inaccurate: ", class)
+	}
+
+	if _, err = s.LookupSingle(sampleTerm, "CS", "225", "complete"); err != ClassNotFound {
+		t.Errorf("LookupSingle of missing class returned %v, want %v", err, ClassNotFound)
+	}
+
+	classes, err := s.LookupDepartment(sampleTerm, "MATH", "basic")
+	if err != nil {
+		t.Fatal("LookupDepartment returned error: ", err)
+	}
+	if len(classes) != 9 {
+		t.Errorf("LookupDepartment returned %d classes, want %d", len(classes), 9)
+	}
+
+	classes, err = s.LookupAll(sampleTerm, "basic")
+	if err != nil {
+		t.Fatal("LookupAll returned error: ", err)
+	}
+	if len(classes) != 10 {
+		t.Errorf("LookupAll returned %d classes, want %d", len(classes), 10)
+	}
+	for _, c := range classes {
+		if c.Description != "" {
+			t.Error("basic LookupAll returned a description: ", c.Description)
+		}
+		if c.Department == "CS" && (len(c.Sections) != 1 || c.Sections[0].Meetings[0].Start != "09:00 AM") {
+			t.Error("basic LookupAll dropped section meetings: ", c.Sections)
+		}
+		if c.Department == "CS" && c.Sections[0].Meetings[0].Building != "" {
+			t.Error("basic LookupAll returned a building: ", c.Sections[0].Meetings[0].Building)
+		}
+	}
+
+	fall := types.Class{Year: 2016, Semester: "fall", Department: "CS", CourseNumber: 125}
+	if err = s.Put(fall); err != nil {
+		t.Fatal("Put returned error: ", err)
+	}
+	classes, _ = s.LookupDepartment(sampleTerm, "CS", "basic")
+	if len(classes) != 1 {
+		t.Errorf("LookupDepartment returned %d classes across terms, want %d", len(classes), 1)
+	}
+
+	terms, err := s.Terms()
+	if err != nil {
+		t.Fatal("Terms returned error: ", err)
+	}
+	if len(terms) != 2 || terms[0] != sampleTerm || terms[1] != fall.Term() {
+		t.Errorf("Terms returned %v, want [%v %v]", terms, sampleTerm, fall.Term())
+	}
+
+	if err = s.Purge(); err != nil {
+		t.Fatal("Purge returned error: ", err)
+	}
+	classes, err = s.LookupAll(sampleTerm, "complete")
+	if err != nil {
+		t.Fatal("LookupAll returned error: ", err)
 	}
 	if len(classes) > 0 {
-		t.Errorf("Database should be empty, yet contains %d classes.", len(classes))
+		t.Errorf("Store should be empty, yet contains %d classes.", len(classes))
 	}
 }
 
-func TestClose(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("Using a closed session should panic")
-		}
-	}()
+// Run the upsert and remove behavior every Store is expected to share
+// against s.
+func testUpsert(t *testing.T, s Store) {
+	if err := s.Purge(); err != nil {
+		t.Fatal("Purge returned error: ", err)
+	}
 
-	myDB := getDB()
-	myDB.Close()
-	_ = myDB.session.Ping()
+	change, err := s.Upsert(sampleClass)
+	if err != nil || change != Added {
+		t.Fatalf("first Upsert returned %v, %v, want %v", change, err, Added)
+	}
+	first, _ := s.LookupSingle(sampleTerm, "CS", "125", "complete")
+
+	change, err = s.Upsert(sampleClass)
+	if err != nil || change != Unchanged {
+		t.Fatalf("repeated Upsert returned %v, %v, want %v", change, err, Unchanged)
+	}
+
+	updated := sampleClass
+	updated.Name = "Introduction to Computer Science"
+	change, err = s.Upsert(updated)
+	if err != nil || change != Updated {
+		t.Fatalf("changed Upsert returned %v, %v, want %v", change, err, Updated)
+	}
+
+	class, _ := s.LookupSingle(sampleTerm, "CS", "125", "complete")
+	if class.Name != updated.Name {
+		t.Errorf("Upsert did not replace name: got %q, want %q", class.Name, updated.Name)
+	}
+	if class.ID != first.ID {
+		t.Errorf("Upsert changed id from %v to %v", first.ID, class.ID)
+	}
+
+	classes, _ := s.LookupAll(sampleTerm, "basic")
+	if len(classes) != 1 {
+		t.Errorf("Upsert left %d classes, want %d", len(classes), 1)
+	}
+
+	if err = s.Remove(sampleTerm, "CS", 125); err != nil {
+		t.Fatal("Remove returned error: ", err)
+	}
+	if err = s.Remove(sampleTerm, "CS", 125); err != ClassNotFound {
+		t.Errorf("Remove of missing class returned %v, want %v", err, ClassNotFound)
+	}
+	if _, err = s.LookupSingle(sampleTerm, "CS", "125", "complete"); err != ClassNotFound {
+		t.Errorf("LookupSingle of removed class returned %v, want %v", err, ClassNotFound)
+	}
 }
 
-func TestPut(t *testing.T) {
-	myDB := getDB()
-	myDB.Purge()
+// Run the staging, swap and rollback behavior every Generational is expected
+// to share against g.
+func testGenerational(t *testing.T, g Generational) {
+	if err := g.Purge(); err != nil {
+		t.Fatal("Purge returned error: ", err)
+	}
+	if err := g.Put(sampleClass); err != nil {
+		t.Fatal("Put returned error: ", err)
+	}
+
+	staging, err := g.Staging()
+	if err != nil {
+		t.Fatal("Staging returned error: ", err)
+	}
+	defer staging.Close()
+
+	classes, _ := staging.LookupAll(sampleTerm, "basic")
+	if len(classes) != 1 {
+		t.Fatalf("staging started with %d classes, want a copy of the %d live", len(classes), 1)
+	}
+
+	if err = staging.Purge(); err != nil {
+		t.Fatal("Purge of staging returned error: ", err)
+	}
+	for i := 0; i < 3; i++ {
+		err = staging.Put(types.Class{Year: 2016, Semester: "spring", Department: "MATH", CourseNumber: 200 + i})
+		if err != nil {
+			t.Fatal("Put to staging returned error: ", err)
+		}
+	}
+
+	classes, _ = g.LookupAll(sampleTerm, "basic")
+	if len(classes) != 1 {
+		t.Fatalf("staged classes visible before Swap: got %d classes, want %d", len(classes), 1)
+	}
 
-	err := myDB.Put(sampleClass)
+	resumed, err := g.ResumeStaging()
 	if err != nil {
-		t.Error("Put returned error: ", err)
+		t.Fatal("ResumeStaging returned error: ", err)
+	}
+	classes, _ = resumed.LookupAll(sampleTerm, "basic")
+	resumed.Close()
+	if len(classes) != 3 {
+		t.Fatalf("resumed staging has %d classes, want the %d staged", len(classes), 3)
 	}
-}
 
-func TestLookup(t *testing.T) {
-	myDB := getDB()
-	myDB.Purge()
+	if err = g.Swap(); err != nil {
+		t.Fatal("Swap returned error: ", err)
+	}
+	classes, _ = g.LookupAll(sampleTerm, "basic")
+	if len(classes) != 3 {
+		t.Fatalf("after Swap got %d classes, want %d", len(classes), 3)
+	}
+	if _, err = g.ResumeStaging(); err != NothingStaged {
+		t.Errorf("ResumeStaging after Swap returned %v, want %v", err, NothingStaged)
+	}
 
-	err := myDB.Put(sampleClass)
+	if err = g.Rollback(); err != nil {
+		t.Fatal("Rollback returned error: ", err)
+	}
+	if _, err = g.LookupSingle(sampleTerm, "CS", "125", "basic"); err != nil {
+		t.Error("Rollback did not restore the previous generation: ", err)
+	}
+
+	if err = g.Rollback(); err != nil {
+		t.Fatal("second Rollback returned error: ", err)
+	}
+	classes, _ = g.LookupAll(sampleTerm, "basic")
+	if len(classes) != 3 {
+		t.Errorf("after second Rollback got %d classes, want %d", len(classes), 3)
+	}
+}
+
+// Run the run log behavior every RunLog is expected to share against l.
+func testRunLog(t *testing.T, l RunLog) {
+	before, err := l.Runs(0)
 	if err != nil {
-		t.Error("Put returned error: ", err)
+		t.Fatal("Runs returned error: ", err)
 	}
-	class, err := myDB.Lookup("CS", "125")
+
+	start := time.Now().Truncate(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		run := types.ScrapeRun{
+			Start:        start.Add(time.Duration(i) * time.Minute),
+			TermURLs:     []string{"http://example.com/2016/spring.xml"},
+			ScrapeCounts: types.ScrapeCounts{Added: i},
+			Departments:  map[string]types.ScrapeCounts{"CS": {Added: i}},
+			Statuses:     map[string]int{"200": 10 + i},
+		}
+		if err = l.PutRun(run); err != nil {
+			t.Fatal("PutRun returned error: ", err)
+		}
+	}
+
+	runs, err := l.Runs(2)
 	if err != nil {
-		t.Error("Class lookup returned error: ", err)
+		t.Fatal("Runs returned error: ", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Runs(2) returned %d runs", len(runs))
 	}
-	if class.Department != "CS" || class.CourseNumber != "125" {
-		t.Error("Lookup result inaccurate: ", class)
+	if runs[0].Added != 2 || runs[1].Added != 1 {
+		t.Errorf("Runs returned runs with %d and %d added, want the newest first", runs[0].Added, runs[1].Added)
+	}
+	if runs[0].Departments["CS"].Added != 2 || runs[0].Statuses["200"] != 12 {
+		t.Errorf("Runs returned %+v, want the departments and statuses put", runs[0])
+	}
+	if !runs[0].Start.Equal(start.Add(2 * time.Minute)) {
+		t.Errorf("Runs returned start %v, want %v", runs[0].Start, start.Add(2*time.Minute))
+	}
+
+	all, _ := l.Runs(0)
+	if len(all) != len(before)+3 {
+		t.Errorf("Runs(0) returned %d runs, want %d", len(all), len(before)+3)
 	}
 }
 
-func TestGetAll(t *testing.T) {
-	myDB := getDB()
-	myDB.Purge()
+// Run the validator behavior every ValidatorLog is expected to share against
+// s. Its live generation is swapped at least once.
+func testValidatorLog(t *testing.T, s interface {
+	Generational
+	ValidatorLog
+}) {
+	all := map[string]types.DocumentValidators{
+		"http://example.com/2016/spring/CS/125.xml": {ETag: `"a"`},
+		"http://example.com/2016/spring/CS/225.xml": {LastModified: "Mon, 04 Jan 2016 00:00:00 GMT"},
+	}
+
+	tests := []struct {
+		desc string
+		drop func() error
+	}{
+		{"Purge", s.Purge},
+		{"Rollback", s.Rollback},
+	}
+
+	for _, tt := range tests {
+		if err := s.PutValidators(1, all); err != nil {
+			t.Fatal("PutValidators returned error: ", err)
+		}
 
-	for i := 0; i < 10; i++ {
-		err := myDB.Put(types.Class{
-			Department: string(i),
-		})
+		saved, err := s.Validators(1)
 		if err != nil {
-			t.Error("Put resulted in error: ", err)
+			t.Fatal("Validators returned error: ", err)
+		}
+		if !reflect.DeepEqual(saved, all) {
+			t.Errorf("Validators returned %v, want %v", saved, all)
+		}
+		if saved, _ = s.Validators(2); len(saved) != 0 {
+			t.Errorf("Validators of another parser version returned %v, want none", saved)
+		}
+
+		if err = tt.drop(); err != nil {
+			t.Fatalf("%s returned error: %v", tt.desc, err)
+		}
+		if saved, _ = s.Validators(1); len(saved) != 0 {
+			t.Errorf("Validators after %s returned %v, want none", tt.desc, saved)
 		}
 	}
+}
 
-	classes, err := myDB.GetAll(DetailBasic)
-	if err != nil {
-		t.Error("GetAll resulted in error: ", err)
+func TestMeetingStoredWithoutMinutes(t *testing.T) {
+	meeting := func(doc bson.M) types.Meeting {
+		raw, err := bson.Marshal(bson.M{"sections": []bson.M{{"meetings": []bson.M{doc}}}})
+		if err != nil {
+			t.Fatal("Marshal returned error: ", err)
+		}
+
+		var class types.Class
+		if err = bson.Unmarshal(raw, &class); err != nil {
+			t.Fatal("Unmarshal returned error: ", err)
+		}
+		return class.Sections[0].Meetings[0]
 	}
 
-	if len(classes) != 10 {
-		t.Errorf("GetAll returned %d classes. Expected: %s", len(classes), 10)
+	old := meeting(bson.M{"start": "09:00 AM", "end": "09:50 AM"})
+	if old.Timed() || old.Start != "09:00 AM" {
+		t.Errorf("meeting stored without minutes decoded as %+v, want untimed", old)
 	}
+
+	midnight := meeting(bson.M{"start_minutes": 0, "end_minutes": 60})
+	if !midnight.Timed() || midnight.StartMinutes != 0 || midnight.EndMinutes != 60 {
+		t.Errorf("meeting stored at midnight decoded as %+v", midnight)
+	}
+}
+
+func TestMongoStore(t *testing.T) {
+	myDB := getDB(t)
+	defer myDB.Close()
+
+	testStore(t, myDB)
+	testUpsert(t, myDB)
+	testGenerational(t, myDB)
+	testValidatorLog(t, myDB)
+	testRunLog(t, myDB)
+}
+
+func TestClose(t *testing.T) {
+	myDB := getDB(t)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Using a closed session should panic")
+		}
+	}()
+
+	myDB.Close()
+	_ = myDB.session.Ping()
 }
@@ -0,0 +1,433 @@
+package db
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// Memory is a Store that keeps every Class in memory. It is meant for tests
+// and small deployments that don't want to run MongoDB. Lookups honor the
+// same DetailLevels projections as the MongoDB backend.
+type Memory struct {
+	mu      sync.RWMutex
+	classes []types.Class
+
+	// Generations for Staging, Swap and Rollback. staged is set while the
+	// staging generation waits to be swapped.
+	staging  *Memory
+	staged   bool
+	previous []types.Class
+	swapped  bool
+
+	// Scrape runs, oldest first.
+	runs []types.ScrapeRun
+
+	// Validators of the documents the live classes were scraped from, and
+	// the parser version that saved them.
+	validators        map[string]types.DocumentValidators
+	validatorsVersion int
+}
+
+// Ensure the in-memory backend satisfies Generational.
+var _ Generational = (*Memory)(nil)
+
+// Construct a new, empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Remove every Class from the store, along with the saved validators.
+func (m *Memory) Purge() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.classes = nil
+	m.validators = nil
+	return nil
+}
+
+// Close the store. Memory holds no resources so this is a no-op.
+func (m *Memory) Close() {}
+
+// Return the in-memory store holding the staging generation, reset to a copy
+// of the live generation.
+func (m *Memory) Staging() (Store, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.staging == nil {
+		m.staging = NewMemory()
+	}
+
+	m.staging.mu.Lock()
+	defer m.staging.mu.Unlock()
+
+	// Stored classes are never modified in place so sharing them is safe.
+	m.staging.classes = append([]types.Class(nil), m.classes...)
+	m.staged = true
+
+	return m.staging, nil
+}
+
+// Return the in-memory store holding the staging generation as it was left.
+func (m *Memory) ResumeStaging() (Store, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.staged {
+		return nil, NothingStaged
+	}
+	return m.staging, nil
+}
+
+// Make the staging generation live, keeping the live one for Rollback.
+func (m *Memory) Swap() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.staging == nil {
+		return NothingStaged
+	}
+
+	m.staging.mu.Lock()
+	defer m.staging.mu.Unlock()
+
+	m.previous, m.classes = m.classes, m.staging.classes
+	m.staging.classes = nil
+	m.staged = false
+	m.swapped = true
+
+	return nil
+}
+
+// Exchange the live and previous generations, dropping the saved
+// validators.
+func (m *Memory) Rollback() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.swapped {
+		return NoPreviousGeneration
+	}
+
+	m.previous, m.classes = m.classes, m.previous
+	m.validators = nil
+	return nil
+}
+
+// Put Class into the store.
+func (m *Memory) Put(entry types.Class) error {
+	if entry.ID == "" {
+		entry.ID = bson.NewObjectId()
+	}
+
+	stored, err := project(entry, nil)
+	if err != nil {
+		log.Error("failed to copy class into memory")
+		return InternalError
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.classes = append(m.classes, stored)
+	return nil
+}
+
+// Insert or replace the Class with the same term, department and course
+// number.
+func (m *Memory) Upsert(entry types.Class) (Change, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.find(entry.Term(), entry.Department, entry.CourseNumber)
+	if i < 0 {
+		entry.ID = bson.NewObjectId()
+	} else {
+		entry.ID = m.classes[i].ID
+	}
+
+	stored, err := project(entry, nil)
+	if err != nil {
+		log.Error("failed to copy class into memory")
+		return Unchanged, InternalError
+	}
+
+	if i < 0 {
+		m.classes = append(m.classes, stored)
+		return Added, nil
+	}
+
+	if reflect.DeepEqual(m.classes[i], stored) {
+		return Unchanged, nil
+	}
+
+	m.classes[i] = stored
+	return Updated, nil
+}
+
+// Remove the Class with the given term, department and course number.
+func (m *Memory) Remove(term types.Term, department string, number int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.find(term, department, number)
+	if i < 0 {
+		return ClassNotFound
+	}
+
+	m.classes = append(m.classes[:i:i], m.classes[i+1:]...)
+	return nil
+}
+
+// Return the index of the Class with the given term, department and course
+// number or -1 if there is none. The caller must hold the lock.
+func (m *Memory) find(term types.Term, department string, number int) int {
+	for i, class := range m.classes {
+		if class.Term() == term && class.Department == department && class.CourseNumber == number {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Lookup Class in the store.
+func (m *Memory) LookupSingle(term types.Term, department, number, detail string) (types.Class, error) {
+	proj := DetailLevels[detail+"_single"]
+
+	courseNum, _ := strconv.Atoi(number)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if i := m.find(term, department, courseNum); i >= 0 {
+		return projectOne(m.classes[i], proj)
+	}
+
+	log.Warn("failed to find class in memory")
+	return types.Class{}, ClassNotFound
+}
+
+// Lookup all Classes in a department in a term in the store.
+func (m *Memory) LookupDepartment(term types.Term, department, detail string) ([]types.Class, error) {
+	proj := DetailLevels[detail+"_department"]
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]types.Class, 0)
+	for _, class := range m.classes {
+		if class.Term() != term || class.Department != department {
+			continue
+		}
+
+		projected, err := projectOne(class, proj)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, projected)
+	}
+
+	return result, nil
+}
+
+// Get All Classes in a term from the store.
+func (m *Memory) LookupAll(term types.Term, detail string) ([]types.Class, error) {
+	proj := DetailLevels[detail+"_all"]
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]types.Class, 0)
+	for _, class := range m.classes {
+		if class.Term() != term {
+			continue
+		}
+
+		projected, err := projectOne(class, proj)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, projected)
+	}
+
+	return result, nil
+}
+
+// List every term with classes in the store, oldest first.
+func (m *Memory) Terms() ([]types.Term, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[types.Term]bool)
+	terms := make([]types.Term, 0)
+	for _, class := range m.classes {
+		if !seen[class.Term()] {
+			seen[class.Term()] = true
+			terms = append(terms, class.Term())
+		}
+	}
+	sortTerms(terms)
+
+	return terms, nil
+}
+
+// Apply proj to class, logging and hiding the cause on failure.
+func projectOne(class types.Class, proj interface{}) (types.Class, error) {
+	result, err := project(class, proj)
+	if err != nil {
+		log.Error("failed to apply projection to class: ", err)
+		return types.Class{}, InternalError
+	}
+
+	return result, nil
+}
+
+// Return a copy of class containing only the fields selected by proj, a
+// MongoDB style inclusion projection from DetailLevels. A nil projection
+// copies the whole class. Stores that don't evaluate projections themselves
+// use this to match the MongoDB backend.
+func project(class types.Class, proj interface{}) (types.Class, error) {
+	var result types.Class
+
+	raw, err := bson.Marshal(class)
+	if err != nil {
+		return result, err
+	}
+
+	if fields, ok := proj.(bson.M); ok {
+		doc := bson.M{}
+		if err = bson.Unmarshal(raw, &doc); err != nil {
+			return result, err
+		}
+
+		// The id is always returned, as MongoDB does by default.
+		paths := []string{"_id"}
+		for path := range fields {
+			paths = append(paths, path)
+		}
+
+		raw, err = bson.Marshal(selectFields(doc, buildPathTree(paths)))
+		if err != nil {
+			return result, err
+		}
+	}
+
+	err = bson.Unmarshal(raw, &result)
+	return result, err
+}
+
+// pathTree holds dotted projection paths split into their components. A leaf
+// selects the whole value under its path.
+type pathTree map[string]pathTree
+
+// Build a pathTree from dotted paths like "sections.meetings.type".
+func buildPathTree(paths []string) pathTree {
+	tree := pathTree{}
+	for _, path := range paths {
+		node := tree
+		for _, part := range strings.Split(path, ".") {
+			if node[part] == nil {
+				node[part] = pathTree{}
+			}
+			node = node[part]
+		}
+	}
+
+	return tree
+}
+
+// Keep only the fields of doc named in tree, descending into embedded
+// documents and arrays of documents.
+func selectFields(doc bson.M, tree pathTree) bson.M {
+	result := bson.M{}
+	for field, sub := range tree {
+		value, ok := doc[field]
+		if !ok {
+			continue
+		}
+
+		if len(sub) == 0 {
+			result[field] = value
+			continue
+		}
+
+		switch v := value.(type) {
+		case bson.M:
+			result[field] = selectFields(v, sub)
+		case []interface{}:
+			list := make([]interface{}, 0, len(v))
+			for _, elem := range v {
+				if elemDoc, ok := elem.(bson.M); ok {
+					list = append(list, selectFields(elemDoc, sub))
+				}
+			}
+			result[field] = list
+		default:
+			result[field] = value
+		}
+	}
+
+	return result
+}
+
+// Record a scrape run.
+func (m *Memory) PutRun(run types.ScrapeRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs = append(m.runs, run)
+	return nil
+}
+
+// Return the latest scrape runs, newest first.
+func (m *Memory) Runs(limit int) ([]types.ScrapeRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if limit <= 0 || limit > len(m.runs) {
+		limit = len(m.runs)
+	}
+
+	runs := make([]types.ScrapeRun, 0, limit)
+	for i := len(m.runs) - 1; len(runs) < limit; i-- {
+		runs = append(runs, m.runs[i])
+	}
+	return runs, nil
+}
+
+// Return the validators saved by the given parser version.
+func (m *Memory) Validators(version int) (map[string]types.DocumentValidators, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	all := make(map[string]types.DocumentValidators)
+	if version != m.validatorsVersion {
+		return all, nil
+	}
+	for url, v := range m.validators {
+		all[url] = v
+	}
+	return all, nil
+}
+
+// Replace the saved validators with all.
+func (m *Memory) PutValidators(version int, all map[string]types.DocumentValidators) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.validators = make(map[string]types.DocumentValidators, len(all))
+	for url, v := range all {
+		m.validators[url] = v
+	}
+	m.validatorsVersion = version
+	return nil
+}
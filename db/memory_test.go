@@ -0,0 +1,64 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemory())
+	testUpsert(t, NewMemory())
+	testRunLog(t, NewMemory())
+}
+
+func TestMemoryGenerational(t *testing.T) {
+	m := NewMemory()
+	if err := m.Rollback(); err != NoPreviousGeneration {
+		t.Errorf("Rollback of new store returned %v, want %v", err, NoPreviousGeneration)
+	}
+
+	testGenerational(t, m)
+	testValidatorLog(t, m)
+}
+
+func TestMemoryCopiesClasses(t *testing.T) {
+	m := NewMemory()
+	if err := m.Put(sampleClass); err != nil {
+		t.Fatal("Put returned error: ", err)
+	}
+
+	class, err := m.LookupSingle(sampleTerm, "CS", "125", "complete")
+	if err != nil {
+		t.Fatal("LookupSingle returned error: ", err)
+	}
+	class.Sections[0].Code = "changed"
+
+	class, err = m.LookupSingle(sampleTerm, "CS", "125", "complete")
+	if err != nil {
+		t.Fatal("LookupSingle returned error: ", err)
+	}
+	if class.Sections[0].Code != "AL1" {
+		t.Error("modifying a lookup result changed the stored class")
+	}
+}
+
+func TestProject(t *testing.T) {
+	class, err := project(sampleClass, DetailLevels["basic_all"])
+	if err != nil {
+		t.Fatal("project returned error: ", err)
+	}
+
+	if class.Name != sampleClass.Name || class.CourseNumber != sampleClass.CourseNumber {
+		t.Error("projection dropped selected fields: ", class)
+	}
+	if class.Description != "" {
+		t.Error("projection kept unselected field description")
+	}
+
+	meeting := class.Sections[0].Meetings[0]
+	if meeting.Type.Code != "LEC" || meeting.Days != "MWF" {
+		t.Error("projection dropped selected meeting fields: ", meeting)
+	}
+	if meeting.Building != "" {
+		t.Error("projection kept unselected field building")
+	}
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"sort"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// Store is the set of operations coursestore needs from a course backend.
+// The API serves classes out of a Store and the scraper fills one, so any
+// backend implementing it can be used in place of MongoDB. A store holds the
+// classes of any number of terms; a Class is identified by its term,
+// department and course number.
+type Store interface {
+	// Put Class into the store.
+	Put(entry types.Class) error
+
+	// Insert or replace the Class with the same term, department and course
+	// number, keeping the id of a replaced Class.
+	Upsert(entry types.Class) (Change, error)
+
+	// Remove the Class with the given term, department and course number.
+	Remove(term types.Term, department string, number int) error
+
+	// Lookup a single Class in a term by department and course number.
+	LookupSingle(term types.Term, department, number, detail string) (types.Class, error)
+
+	// Lookup every Class in a department in a term.
+	LookupDepartment(term types.Term, department, detail string) ([]types.Class, error)
+
+	// Lookup every Class in a term.
+	LookupAll(term types.Term, detail string) ([]types.Class, error)
+
+	// List every term with classes in the store, oldest first.
+	Terms() ([]types.Term, error)
+
+	// Remove every Class from the store.
+	Purge() error
+
+	// Release any resources held by the store.
+	Close()
+}
+
+// Change describes what an Upsert did to the store.
+type Change int
+
+const (
+	// The Class was already stored with identical contents.
+	Unchanged Change = iota
+
+	// The Class was not stored before.
+	Added
+
+	// A stored Class was replaced with different contents.
+	Updated
+)
+
+// Generational is implemented by stores that can build a new generation of
+// classes beside the live one and then swap it into place, so readers never
+// see a partially written store. The generation that was replaced is kept
+// for Rollback.
+type Generational interface {
+	Store
+
+	// Return a Store on the staging generation, starting out as a copy of
+	// the live generation. Writes to it are not visible through the live
+	// store until Swap.
+	Staging() (Store, error)
+
+	// Return a Store on the staging generation as an earlier scrape left
+	// it, without resetting it. NothingStaged is returned if there is no
+	// staging generation waiting to be swapped.
+	ResumeStaging() (Store, error)
+
+	// Make the staging generation live, keeping the live generation as the
+	// previous one. Readers see either the old or the new live generation,
+	// but only some backends make the whole exchange atomic; see each for
+	// what a failure leaves behind.
+	Swap() error
+
+	// Exchange the live and previous generations. Like Swap, only some
+	// backends do so atomically.
+	Rollback() error
+}
+
+// RunLog is implemented by stores that keep a history of scrape runs beside
+// their classes. Runs don't belong to any generation, so Swap and Rollback
+// leave them alone.
+type RunLog interface {
+	// Record a scrape run.
+	PutRun(run types.ScrapeRun) error
+
+	// Return the latest runs, newest first. All runs are returned if limit
+	// is not positive.
+	Runs(limit int) ([]types.ScrapeRun, error)
+}
+
+// ValidatorLog is implemented by stores that keep the HTTP validators of the
+// documents their live classes were scraped from, so a conditional scrape
+// can skip documents that haven't changed. Validators vouch for the live
+// classes, so Purge and Rollback drop them.
+type ValidatorLog interface {
+	// Return the validators saved by the given parser version, keyed by
+	// URL. Validators saved by any other version are left out.
+	Validators(version int) (map[string]types.DocumentValidators, error)
+
+	// Replace the saved validators with all, saved by the given parser
+	// version.
+	PutValidators(version int, all map[string]types.DocumentValidators) error
+}
+
+// Ensure every backend keeps a RunLog and a ValidatorLog.
+var (
+	_ RunLog = (*DB)(nil)
+	_ RunLog = (*Memory)(nil)
+	_ RunLog = (*Bolt)(nil)
+
+	_ ValidatorLog = (*DB)(nil)
+	_ ValidatorLog = (*Memory)(nil)
+	_ ValidatorLog = (*Bolt)(nil)
+)
+
+// A document's validators as stored, with the parser version that saved
+// them.
+type storedValidators struct {
+	URL                      string `bson:"_id"`
+	Version                  int    `bson:"version"`
+	types.DocumentValidators `bson:",inline"`
+}
+
+// Ensure the MongoDB backend satisfies Generational.
+var _ Generational = (*DB)(nil)
+
+// Sort terms oldest first.
+func sortTerms(terms []types.Term) {
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i].Before(terms[j])
+	})
+}
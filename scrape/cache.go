@@ -0,0 +1,66 @@
+package scrape
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// NotCached is returned by an offline Scraper for documents missing from its
+// cache.
+var NotCached = errors.New("Document not cached")
+
+// A directory of raw documents fetched from the course API, keyed by URL.
+type cache struct {
+	dir string
+}
+
+// Return the file holding the document at url. Files are spread over
+// subdirectories by the first byte of the URL's hash.
+func (c *cache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, key[:2], key+".xml")
+}
+
+// Read the document at url from the cache, returning NotCached if it was
+// never stored.
+func (c *cache) get(url string) ([]byte, error) {
+	data, err := ioutil.ReadFile(c.path(url))
+	if os.IsNotExist(err) {
+		return nil, NotCached
+	}
+	return data, err
+}
+
+// Store the document at url in the cache, replacing any older copy.
+func (c *cache) put(url string, data []byte) error {
+	return writeFile(c.path(url), data)
+}
+
+// Write data to the file at path. The file is written aside and renamed
+// into place so readers never see part of it.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
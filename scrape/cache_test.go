@@ -0,0 +1,162 @@
+package scrape
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// Make a temporary cache directory, returning it and a function removing it.
+func tempCacheDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "coursestore-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestCache(t *testing.T) {
+	dir, cleanup := tempCacheDir(t)
+	defer cleanup()
+
+	c := &cache{dir: dir}
+	if _, err := c.get("http://example.com/a.xml"); err != NotCached {
+		t.Errorf("get of empty cache returned %v, want %v", err, NotCached)
+	}
+
+	for _, doc := range []string{"<a/>", "<b/>"} {
+		if err := c.put("http://example.com/a.xml", []byte(doc)); err != nil {
+			t.Fatal("put returned error: ", err)
+		}
+
+		data, err := c.get("http://example.com/a.xml")
+		if err != nil {
+			t.Fatal("get returned error: ", err)
+		}
+		if string(data) != doc {
+			t.Errorf("get returned %q, want %q", data, doc)
+		}
+	}
+}
+
+// Error if a term digested online can't be digested again from the cache
+// once the course API is gone
+func TestDigestAllOffline(t *testing.T) {
+	dir, cleanup := tempCacheDir(t)
+	defer cleanup()
+
+	server := testServer(t)
+	url := server.URL + "/schedule/2016/spring.xml"
+
+	online := New(Config{Retries: testRetries, CacheDir: dir})
+	want, _, err := digestTerm(t, context.Background(), online, url, Options{})
+	if err != nil {
+		t.Fatal("online DigestAll returned error: ", err)
+	}
+	server.Close()
+
+	offline := New(Config{CacheDir: dir, Offline: true})
+	classes, report, err := digestTerm(t, context.Background(), offline, url, Options{})
+	if err != nil {
+		t.Fatal("offline DigestAll returned error: ", err)
+	}
+
+	if len(classes) != len(want) {
+		t.Errorf("offline DigestAll sent %d classes, want %d", len(classes), len(want))
+	}
+	if len(report.Failures) != 1 || report.Failures[0].Err != MalformedCourseError {
+		t.Errorf("offline DigestAll reported %+v, want only the malformed course", report.Failures)
+	}
+}
+
+func TestGetXMLOfflineMiss(t *testing.T) {
+	dir, cleanup := tempCacheDir(t)
+	defer cleanup()
+
+	s := New(Config{CacheDir: dir, Offline: true})
+	if _, err := s.GetXML(context.Background(), "http://example.com/missing.xml"); err != NotCached {
+		t.Errorf("GetXML of uncached document returned %v, want %v", err, NotCached)
+	}
+}
+
+// A ValidatorStore keeping the validators saved by one parser version in
+// memory.
+type testValidators struct {
+	version int
+	all     map[string]types.DocumentValidators
+}
+
+func (v *testValidators) Validators(version int) (map[string]types.DocumentValidators, error) {
+	all := make(map[string]types.DocumentValidators)
+	if version == v.version {
+		for url, saved := range v.all {
+			all[url] = saved
+		}
+	}
+	return all, nil
+}
+
+func (v *testValidators) PutValidators(version int, all map[string]types.DocumentValidators) error {
+	v.version, v.all = version, all
+	return nil
+}
+
+// Error if a conditional scrape digests courses that haven't changed since
+// validators were last saved by the same parser version
+func TestDigestAllConditional(t *testing.T) {
+	dir, cleanup := tempCacheDir(t)
+	defer cleanup()
+
+	server := testServer(t)
+	defer server.Close()
+	url := server.URL + "/schedule/2016/spring.xml"
+	store := &testValidators{}
+	config := Config{Retries: testRetries, CacheDir: dir, Conditional: true, Validators: store}
+
+	runs := []struct {
+		save       bool
+		stale      bool
+		classes    int
+		unmodified int
+	}{
+		// Validators aren't used until they are saved.
+		{false, false, 2, 0},
+		{true, false, 2, 0},
+		{true, false, 0, 2},
+
+		// Nor once the parser changes.
+		{true, true, 2, 0},
+		{true, false, 0, 2},
+	}
+
+	for i, run := range runs {
+		if run.stale {
+			store.version = ParserVersion - 1
+		}
+
+		s := New(config)
+		classes, report, err := digestTerm(t, context.Background(), s, url, Options{})
+		if err != nil {
+			t.Fatalf("run %d: DigestAll returned error: %v", i, err)
+		}
+
+		if len(classes) != run.classes || len(report.Unmodified) != run.unmodified {
+			t.Errorf("run %d: sent %d classes and skipped %d, want %d and %d",
+				i, len(classes), len(report.Unmodified), run.classes, run.unmodified)
+		}
+		// The malformed course is never skipped.
+		if len(report.Failures) != 1 {
+			t.Errorf("run %d: reported %d failures, want %d", i, len(report.Failures), 1)
+		}
+
+		if run.save {
+			if err = s.SaveValidators(); err != nil {
+				t.Fatal("SaveValidators returned error: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package scrape
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// FilterMismatch is returned when loading a checkpoint saved by a scrape
+// with a different Filter, whose progress can't be trusted.
+var FilterMismatch = errors.New("Checkpoint saved with a different filter")
+
+type (
+	// Checkpoint records the progress of a scrape in a file so an
+	// interrupted scrape can pick up where it stopped. Progress is kept per
+	// term URL, along with the Filter the scrape ran with. It is safe for
+	// concurrent use.
+	Checkpoint struct {
+		path   string
+		filter Filter
+
+		mu    sync.Mutex
+		terms map[string]*TermProgress
+	}
+
+	// TermProgress records which subjects and courses of a term have been
+	// scraped and stored. Subjects are complete once every course in them
+	// is. A nil TermProgress records nothing.
+	TermProgress struct {
+		mu       sync.Mutex
+		done     bool
+		term     types.Term
+		subjects map[string]bool
+		courses  map[CourseID]bool
+
+		// Courses each subject being scraped still waits on.
+		outstanding map[string]map[CourseID]bool
+	}
+
+	// Layout of a checkpoint file.
+	checkpointFile struct {
+		Filter Filter                `json:"filter"`
+		Terms  map[string]termRecord `json:"terms"`
+	}
+
+	termRecord struct {
+		Done     bool       `json:"done"`
+		Term     types.Term `json:"term"`
+		Subjects []string   `json:"subjects"`
+		Courses  []CourseID `json:"courses"`
+	}
+)
+
+// Construct an empty checkpoint of a scrape with filter, saved to the file
+// at path.
+func NewCheckpoint(path string, filter Filter) *Checkpoint {
+	return &Checkpoint{path: path, filter: filter, terms: make(map[string]*TermProgress)}
+}
+
+// Load the checkpoint saved to the file at path to resume a scrape with
+// filter. An empty checkpoint is returned if there is no file, and
+// FilterMismatch if the checkpoint was saved with a different filter.
+func LoadCheckpoint(path string, filter Filter) (*Checkpoint, error) {
+	c := NewCheckpoint(path, filter)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file checkpointFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Terms) > 0 && !file.Filter.Equal(filter) {
+		return nil, FilterMismatch
+	}
+
+	for url, record := range file.Terms {
+		p := newTermProgress()
+		p.done = record.Done
+		p.term = record.Term
+		for _, subject := range record.Subjects {
+			p.subjects[subject] = true
+		}
+		for _, course := range record.Courses {
+			p.courses[course] = true
+		}
+		c.terms[url] = p
+	}
+
+	return c, nil
+}
+
+func newTermProgress() *TermProgress {
+	return &TermProgress{
+		subjects:    make(map[string]bool),
+		courses:     make(map[CourseID]bool),
+		outstanding: make(map[string]map[CourseID]bool),
+	}
+}
+
+// Return true if the checkpoint holds no progress.
+func (c *Checkpoint) Empty() bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.terms) == 0
+}
+
+// Forget all progress.
+func (c *Checkpoint) Reset() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.terms = make(map[string]*TermProgress)
+}
+
+// Return the progress of the term at url, starting it if needed. Returns nil
+// for a nil checkpoint.
+func (c *Checkpoint) Term(url string) *TermProgress {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.terms[url]
+	if !ok {
+		p = newTermProgress()
+		c.terms[url] = p
+	}
+	return p
+}
+
+// Save the checkpoint to its file.
+func (c *Checkpoint) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file := checkpointFile{Filter: c.filter, Terms: make(map[string]termRecord)}
+	for url, p := range c.terms {
+		file.Terms[url] = p.record()
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return writeFile(c.path, data)
+}
+
+// Remove the checkpoint's file, once the scrape it tracked is over.
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Return the progress in the layout of a checkpoint file, sorted so files
+// are stable.
+func (p *TermProgress) record() termRecord {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	record := termRecord{Done: p.done, Term: p.term}
+	for subject := range p.subjects {
+		record.Subjects = append(record.Subjects, subject)
+	}
+	for course := range p.courses {
+		record.Courses = append(record.Courses, course)
+	}
+
+	sort.Strings(record.Subjects)
+	sortCourses(record.Courses)
+
+	return record
+}
+
+// Return true if the whole term has been scraped.
+func (p *TermProgress) Done() bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+// Mark the whole term scraped, recording the term its URL held.
+func (p *TermProgress) Finish(term types.Term) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = true
+	p.term = term
+}
+
+// Return the term recorded when the term was finished. The zero Term is
+// returned for unfinished terms.
+func (p *TermProgress) Scraped() types.Term {
+	if p == nil {
+		return types.Term{}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.term
+}
+
+// Return every course stored so far.
+func (p *TermProgress) Completed() []CourseID {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	courses := make([]CourseID, 0, len(p.courses))
+	for course := range p.courses {
+		courses = append(courses, course)
+	}
+	return courses
+}
+
+// Complete records that course has been stored. Returns true if that
+// completed its subject.
+func (p *TermProgress) Complete(course CourseID) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.courses[course] = true
+
+	outstanding, ok := p.outstanding[course.Department]
+	if !ok {
+		return false
+	}
+	delete(outstanding, course)
+	if len(outstanding) > 0 {
+		return false
+	}
+
+	delete(p.outstanding, course.Department)
+	p.subjects[course.Department] = true
+	return true
+}
+
+// Return true if every course in subject has been stored.
+func (p *TermProgress) subjectDone(subject string) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.subjects[subject]
+}
+
+// Return true if course has been stored.
+func (p *TermProgress) courseDone(course CourseID) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.courses[course]
+}
+
+// Start waiting on the courses of subject, so it is complete once every one
+// of them is.
+func (p *TermProgress) expect(subject string, courses []CourseID) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	outstanding := make(map[CourseID]bool)
+	for _, course := range courses {
+		if !p.courses[course] {
+			outstanding[course] = true
+		}
+	}
+
+	if len(outstanding) == 0 {
+		p.subjects[subject] = true
+		return
+	}
+	p.outstanding[subject] = outstanding
+}
@@ -0,0 +1,86 @@
+package scrape
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, cleanup := tempCacheDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "checkpoint.json")
+
+	filter := Filter{Departments: []string{"CS"}, Courses: []CourseID{{"MATH", 241}}}
+	c := NewCheckpoint(path, filter)
+	if !c.Empty() {
+		t.Error("new checkpoint isn't empty")
+	}
+
+	p := c.Term("spring")
+	p.expect("CS", []CourseID{{"CS", 125}, {"CS", 225}})
+	if p.Complete(CourseID{"CS", 125}) {
+		t.Error("subject complete before all its courses")
+	}
+	if !p.Complete(CourseID{"CS", 225}) {
+		t.Error("subject not complete after all its courses")
+	}
+	fall := types.Term{Year: 2016, Semester: "fall"}
+	c.Term("fall").Finish(fall)
+
+	if err := c.Save(); err != nil {
+		t.Fatal("Save returned error: ", err)
+	}
+	loaded, err := LoadCheckpoint(path, Filter{Courses: filter.Courses, Departments: []string{"cs"}})
+	if err != nil {
+		t.Fatal("LoadCheckpoint returned error: ", err)
+	}
+
+	spring := loaded.Term("spring")
+	if !spring.subjectDone("CS") || !spring.courseDone(CourseID{"CS", 125}) || spring.Done() {
+		t.Errorf("loaded spring progress %+v, want CS complete", spring.record())
+	}
+	if !loaded.Term("fall").Done() || loaded.Term("fall").Scraped() != fall {
+		t.Errorf("loaded fall progress %+v, want it done with term %v", loaded.Term("fall").record(), fall)
+	}
+
+	for _, other := range []Filter{{}, {Departments: []string{"CS"}}} {
+		if _, err = LoadCheckpoint(path, other); err != FilterMismatch {
+			t.Errorf("LoadCheckpoint with filter %+v returned %v, want %v", other, err, FilterMismatch)
+		}
+	}
+
+	if err = loaded.Remove(); err != nil {
+		t.Fatal("Remove returned error: ", err)
+	}
+	if loaded, err = LoadCheckpoint(path, Filter{}); err != nil || !loaded.Empty() {
+		t.Errorf("LoadCheckpoint after Remove returned %v, want an empty checkpoint", err)
+	}
+}
+
+// Error if DigestAll scrapes what an earlier scrape completed
+func TestDigestAllResumes(t *testing.T) {
+	server := testServer(t)
+	defer server.Close()
+	url := server.URL + "/schedule/2016/spring.xml"
+	s := New(Config{Retries: testRetries})
+
+	progress := newTermProgress()
+	progress.courses[CourseID{"CS", 125}] = true
+
+	classes, _, err := digestTerm(t, context.Background(), s, url, Options{Resume: progress})
+	if err != nil {
+		t.Fatal("DigestAll returned error: ", err)
+	}
+	if len(classes) != 1 || classes[0].CourseNumber != 225 {
+		t.Errorf("resumed DigestAll sent %d classes, want only CS 225", len(classes))
+	}
+
+	progress.subjects["CS"] = true
+	classes, _, _ = digestTerm(t, context.Background(), s, url, Options{Resume: progress})
+	if len(classes) != 0 {
+		t.Errorf("DigestAll of completed subject sent %d classes", len(classes))
+	}
+}
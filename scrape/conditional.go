@@ -0,0 +1,102 @@
+package scrape
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// ParserVersion identifies how documents are digested into classes. Bump it
+// whenever a change to the parser changes the classes it produces, so
+// conditional scrapes stop trusting validators saved by the older parser and
+// digest every document again.
+const ParserVersion = 5
+
+// Return the validators a response carries.
+func responseValidators(resp *http.Response) types.DocumentValidators {
+	return types.DocumentValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// Make req conditional on the document having changed since v was received.
+func applyValidators(req *http.Request, v types.DocumentValidators) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// Return the validators saved for url by the last successful scrape.
+func (s *Scraper) savedValidators(url string) types.DocumentValidators {
+	if !s.conditional {
+		return types.DocumentValidators{}
+	}
+
+	s.validatorsOnce.Do(func() {
+		saved, err := s.validators.Validators(ParserVersion)
+		if err != nil {
+			log.Warn("failed to load validators, fetching every document: ", err)
+			saved = make(map[string]types.DocumentValidators)
+		}
+
+		s.mu.Lock()
+		s.saved = saved
+		s.mu.Unlock()
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saved[url]
+}
+
+// Remember the validators of a fetched document until they are saved.
+func (s *Scraper) keepValidators(url string, v types.DocumentValidators) {
+	if !s.conditional || (v.ETag == "" && v.LastModified == "") {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[url] = v
+}
+
+// Drop the validators of a document that couldn't be used, so it is fetched
+// in full next time.
+func (s *Scraper) forgetValidators(url string) {
+	if !s.conditional {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, url)
+	delete(s.saved, url)
+}
+
+// SaveValidators saves the validators of the documents fetched so far, so the
+// next scrape only downloads documents that changed since. Call it once the
+// scraped classes are live in the store the validators are saved to:
+// documents that haven't changed are skipped from then on, on the
+// assumption the store still holds them.
+func (s *Scraper) SaveValidators() error {
+	if !s.conditional {
+		return nil
+	}
+	s.savedValidators("")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for url, v := range s.pending {
+		s.saved[url] = v
+	}
+	s.pending = make(map[string]types.DocumentValidators)
+
+	return s.validators.PutValidators(ParserVersion, s.saved)
+}
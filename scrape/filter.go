@@ -0,0 +1,123 @@
+package scrape
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter restricts a scrape to some departments and courses. A course is
+// scraped if its department is in Departments or it is in Courses. The zero
+// Filter scrapes everything.
+type Filter struct {
+	Departments []string   `json:"departments,omitempty"`
+	Courses     []CourseID `json:"courses,omitempty"`
+}
+
+// Parse a course id like "CS 225".
+func ParseCourseID(id string) (CourseID, error) {
+	parts := strings.Fields(id)
+	if len(parts) != 2 {
+		return CourseID{}, MalformedCourseError
+	}
+
+	number, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return CourseID{}, MalformedCourseError
+	}
+
+	return CourseID{Department: strings.ToUpper(parts[0]), Number: number}, nil
+}
+
+// Return true if the filter lets everything through.
+func (f Filter) Empty() bool {
+	return len(f.Departments) == 0 && len(f.Courses) == 0
+}
+
+// Return true if f and other scrape the same courses, regardless of order
+// and case.
+func (f Filter) Equal(other Filter) bool {
+	return reflect.DeepEqual(f.normalize(), other.normalize())
+}
+
+// Return a copy of the filter with departments in upper case, sorted and
+// without duplicates.
+func (f Filter) normalize() Filter {
+	var n Filter
+
+	seen := make(map[string]bool)
+	for _, d := range f.Departments {
+		d = strings.ToUpper(d)
+		if !seen[d] {
+			seen[d] = true
+			n.Departments = append(n.Departments, d)
+		}
+	}
+
+	courses := make(map[CourseID]bool)
+	for _, c := range f.Courses {
+		c.Department = strings.ToUpper(c.Department)
+		if !courses[c] {
+			courses[c] = true
+			n.Courses = append(n.Courses, c)
+		}
+	}
+
+	sort.Strings(n.Departments)
+	sortCourses(n.Courses)
+	return n
+}
+
+// Sort courses by department, then number.
+func sortCourses(courses []CourseID) {
+	sort.Slice(courses, func(i, j int) bool {
+		a, b := courses[i], courses[j]
+		if a.Department != b.Department {
+			return a.Department < b.Department
+		}
+		return a.Number < b.Number
+	})
+}
+
+// Return true if every course in department is scraped.
+func (f Filter) allOf(department string) bool {
+	if f.Empty() {
+		return true
+	}
+
+	for _, d := range f.Departments {
+		if strings.EqualFold(d, department) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if any course in department is scraped.
+func (f Filter) Subject(department string) bool {
+	if f.allOf(department) {
+		return true
+	}
+
+	for _, course := range f.Courses {
+		if strings.EqualFold(course.Department, department) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if course is scraped.
+func (f Filter) Course(course CourseID) bool {
+	if f.allOf(course.Department) {
+		return true
+	}
+
+	for _, c := range f.Courses {
+		if c.Number == course.Number && strings.EqualFold(c.Department, course.Department) {
+			return true
+		}
+	}
+	return false
+}
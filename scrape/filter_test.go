@@ -0,0 +1,81 @@
+package scrape
+
+import (
+	"context"
+	"testing"
+)
+
+var parseCourseIDTests = []struct {
+	id   string
+	want CourseID
+	err  error
+}{
+	{"CS 225", CourseID{"CS", 225}, nil},
+	{" math  241 ", CourseID{"MATH", 241}, nil},
+	{"CS", CourseID{}, MalformedCourseError},
+	{"CS two", CourseID{}, MalformedCourseError},
+	{"CS 225 A", CourseID{}, MalformedCourseError},
+}
+
+func TestParseCourseID(t *testing.T) {
+	for _, tt := range parseCourseIDTests {
+		id, err := ParseCourseID(tt.id)
+		if id != tt.want || err != tt.err {
+			t.Errorf("ParseCourseID(%q) = %v, %v, want %v, %v", tt.id, id, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	filter := Filter{
+		Departments: []string{"MATH"},
+		Courses:     []CourseID{{"CS", 225}},
+	}
+
+	tests := []struct {
+		course  CourseID
+		subject bool
+		include bool
+	}{
+		{CourseID{"MATH", 241}, true, true},
+		{CourseID{"math", 241}, true, true},
+		{CourseID{"CS", 225}, true, true},
+		{CourseID{"CS", 125}, true, false},
+		{CourseID{"PHYS", 211}, false, false},
+	}
+
+	for _, tt := range tests {
+		if subject := filter.Subject(tt.course.Department); subject != tt.subject {
+			t.Errorf("Subject(%q) = %t, want %t", tt.course.Department, subject, tt.subject)
+		}
+		if include := filter.Course(tt.course); include != tt.include {
+			t.Errorf("Course(%v) = %t, want %t", tt.course, include, tt.include)
+		}
+		if !(Filter{}).Course(tt.course) {
+			t.Errorf("empty filter excludes %v", tt.course)
+		}
+	}
+}
+
+// Error if DigestAll scrapes courses the filter doesn't select
+func TestDigestAllFiltered(t *testing.T) {
+	server := testServer(t)
+	defer server.Close()
+	url := server.URL + "/schedule/2016/spring.xml"
+	s := New(Config{Retries: testRetries})
+
+	filter := Filter{Courses: []CourseID{{"CS", 225}}}
+	classes, report, err := digestTerm(t, context.Background(), s, url, Options{Filter: filter})
+	if err != nil {
+		t.Fatal("DigestAll returned error: ", err)
+	}
+	if len(classes) != 1 || classes[0].CourseNumber != 225 || len(report.Failures) != 0 {
+		t.Errorf("filtered DigestAll sent %d classes and %d failures, want only CS 225",
+			len(classes), len(report.Failures))
+	}
+
+	filter = Filter{Departments: []string{"MATH"}}
+	if classes, _, _ = digestTerm(t, context.Background(), s, url, Options{Filter: filter}); len(classes) != 0 {
+		t.Errorf("DigestAll of another department sent %d classes", len(classes))
+	}
+}
@@ -0,0 +1,141 @@
+package scrape
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Slowest a limiter gets when the course API is overloaded, as a fraction of
+// its configured rate.
+const minRateFraction = 1.0 / 32
+
+// A token bucket limiting how many requests start per second. The rate is
+// halved whenever the course API signals it is overloaded and creeps back up
+// to the configured rate as requests succeed. A nil limiter doesn't limit.
+type limiter struct {
+	mu sync.Mutex
+
+	// Configured and current requests per second.
+	max  float64
+	rate float64
+
+	// Most tokens the bucket holds, and the tokens held when last updated.
+	// Tokens go negative while requests wait their turn.
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Construct a limiter starting rate requests per second, allowing up to burst
+// at once. Returns nil if rate isn't positive.
+func newLimiter(rate float64, burst int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(rate)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &limiter{
+		max:    rate,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Add the tokens earned since the last update. The caller must hold the lock.
+func (l *limiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// Wait until a request may start. Returns the context's error if it is
+// canceled first.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	l.refill(time.Now())
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give back the token that was never used.
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Halve the rate after the course API turned a request away, and drop any
+// saved up burst.
+func (l *limiter) slowDown() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.rate /= 2
+	if min := l.max * minRateFraction; l.rate < min {
+		l.rate = min
+	}
+	if l.tokens > 0 {
+		l.tokens = 0
+	}
+
+	log.Warn("course API overloaded, slowing to ", l.rate, " requests per second")
+}
+
+// Raise the rate back toward the configured rate after a request succeeded.
+func (l *limiter) speedUp() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == l.max {
+		return
+	}
+
+	l.refill(time.Now())
+	l.rate += l.max / 20
+	if l.rate > l.max {
+		l.rate = l.max
+	}
+}
+
+// Return the current requests per second.
+func (l *limiter) current() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
@@ -0,0 +1,73 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimiterBurst(t *testing.T) {
+	l := newLimiter(10, 3)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("burst of 3 took %v, want no waiting", elapsed)
+	}
+
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("request after the burst started after %v, want about %v", elapsed, 100*time.Millisecond)
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	l := newLimiter(1, 1)
+	l.wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLimiterAdapts(t *testing.T) {
+	l := newLimiter(64, 1)
+
+	for i := 0; i < 10; i++ {
+		l.slowDown()
+	}
+	if rate := l.current(); rate != 64*minRateFraction {
+		t.Errorf("rate after slowing down is %v, want the minimum %v", rate, 64*minRateFraction)
+	}
+
+	for i := 0; i < 30; i++ {
+		l.speedUp()
+	}
+	if rate := l.current(); rate != 64 {
+		t.Errorf("rate after speeding up is %v, want the configured %v", rate, 64.0)
+	}
+}
+
+// Error if overloaded responses don't slow the scraper down
+func TestGetXMLSlowsDown(t *testing.T) {
+	server, _ := statusServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	s := New(Config{Rate: 1000, Retries: testRetries})
+	if _, err := s.GetXML(context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if rate := s.limit.current(); rate >= 1000 {
+		t.Errorf("rate after 503 responses is %v, want below %v", rate, 1000.0)
+	}
+}
@@ -0,0 +1,115 @@
+package scrape
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// DefaultProgressInterval is how often DigestAll reports progress unless
+// configured otherwise.
+const DefaultProgressInterval = time.Second
+
+// Progress is a snapshot of a running DigestAll.
+type Progress struct {
+	Term types.Term
+
+	// Subjects to scrape, subjects whose course lists have been fetched, and
+	// subjects finished.
+	Subjects       int
+	SubjectsListed int
+	SubjectsDone   int
+
+	// Courses listed so far, courses scraped or skipped, and courses that
+	// failed.
+	Courses       int
+	CoursesDone   int
+	CoursesFailed int
+
+	// Requests to the course API in flight across the scraper.
+	InFlight int
+
+	Elapsed time.Duration
+
+	// Courses finished per second.
+	Throughput float64
+
+	// Estimated time left. Zero until it can be estimated.
+	ETA time.Duration
+
+	// Set in the last report, made once DigestAll ends.
+	Done bool
+}
+
+// Return the progress as structured log fields.
+func (p Progress) Fields() log.Fields {
+	return log.Fields{
+		"year":            p.Term.Year,
+		"semester":        p.Term.Semester,
+		"subjects":        p.Subjects,
+		"subjects_listed": p.SubjectsListed,
+		"subjects_done":   p.SubjectsDone,
+		"courses":         p.Courses,
+		"courses_done":    p.CoursesDone,
+		"courses_failed":  p.CoursesFailed,
+		"in_flight":       p.InFlight,
+		"elapsed":         p.Elapsed.String(),
+		"throughput":      p.Throughput,
+		"eta":             p.ETA.String(),
+	}
+}
+
+// Return a snapshot of the digestion's progress.
+func (d *digestion) snapshot() Progress {
+	d.mu.Lock()
+	p := d.status
+	d.mu.Unlock()
+
+	p.InFlight = d.InFlight()
+	p.Elapsed = time.Since(d.start)
+
+	finished := float64(p.CoursesDone + p.CoursesFailed)
+	if seconds := p.Elapsed.Seconds(); seconds > 0 {
+		p.Throughput = finished / seconds
+	}
+
+	// Courses in subjects not listed yet are extrapolated from those listed.
+	if p.SubjectsListed > 0 && p.Throughput > 0 {
+		total := float64(p.Courses) * float64(p.Subjects) / float64(p.SubjectsListed)
+		if left := total - finished; left > 0 {
+			p.ETA = time.Duration(left / p.Throughput * float64(time.Second))
+		}
+	}
+
+	return p
+}
+
+// Update the digestion's progress with f.
+func (d *digestion) count(f func(p *Progress)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f(&d.status)
+}
+
+// Call report with the digestion's progress every interval until stop is
+// closed, then once more. done is closed once the final report is made.
+func (d *digestion) reportProgress(report func(Progress), interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report(d.snapshot())
+		case <-stop:
+			p := d.snapshot()
+			p.Done = true
+			report(p)
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package scrape
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Error if DigestAll's final progress report doesn't add up
+func TestDigestAllProgress(t *testing.T) {
+	server := testServer(t)
+	defer server.Close()
+	url := server.URL + "/schedule/2016/spring.xml"
+
+	var mu sync.Mutex
+	var reports []Progress
+	options := Options{
+		OnProgress: func(p Progress) {
+			mu.Lock()
+			reports = append(reports, p)
+			mu.Unlock()
+		},
+		ProgressInterval: time.Millisecond,
+	}
+
+	if _, _, err := digestTerm(t, context.Background(), New(Config{Retries: testRetries}), url, options); err != nil {
+		t.Fatal("DigestAll returned error: ", err)
+	}
+
+	if len(reports) == 0 {
+		t.Fatal("DigestAll made no progress reports")
+	}
+	last := reports[len(reports)-1]
+	if !last.Done {
+		t.Error("last progress report isn't marked done")
+	}
+
+	want := Progress{Subjects: 1, SubjectsListed: 1, SubjectsDone: 1, Courses: 3, CoursesDone: 2, CoursesFailed: 1}
+	got := Progress{
+		Subjects: last.Subjects, SubjectsListed: last.SubjectsListed, SubjectsDone: last.SubjectsDone,
+		Courses: last.Courses, CoursesDone: last.CoursesDone, CoursesFailed: last.CoursesFailed,
+	}
+	if got != want {
+		t.Errorf("last progress report %+v, want %+v", got, want)
+	}
+	if last.Term.Year != 2016 || last.Term.Semester != "spring" {
+		t.Errorf("progress reported for %v, want spring 2016", last.Term)
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	d := &digestion{
+		Scraper: New(Config{}),
+		start:   time.Now().Add(-10 * time.Second),
+		status:  Progress{Subjects: 4, SubjectsListed: 2, Courses: 100, CoursesDone: 100},
+	}
+
+	p := d.snapshot()
+	if p.Throughput < 9 || p.Throughput > 11 {
+		t.Errorf("throughput is %v, want about 10 courses per second", p.Throughput)
+	}
+	// Half the subjects are listed, so about 100 courses are left.
+	if p.ETA < 9*time.Second || p.ETA > 11*time.Second {
+		t.Errorf("ETA is %v, want about %v", p.ETA, 10*time.Second)
+	}
+}
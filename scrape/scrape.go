@@ -3,11 +3,15 @@
 package scrape
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -15,11 +19,37 @@ import (
 )
 
 var normalizeCreditHoursRE = regexp.MustCompile(`\d+[\.]*[\d]*`)
+
 var normalizeDegreeAttributesRE = regexp.MustCompile(`, and |, | course\.`)
 
+// Layout of meeting times in course API XML.
+const meetingTimeLayout = "03:04 PM"
+
+// Layout of section dates in course API XML.
+const sectionDateLayout = "2006-01-02Z07:00"
+
+// Parts of the term the course API's part of term codes stand for.
+var partOfTermCodes = map[string]string{
+	"1": types.FullTerm,
+	"A": types.FirstEightWeeks,
+	"B": types.SecondEightWeeks,
+}
+
+// Letters the course API uses for days of the week.
+var weekdayLetters = map[rune]time.Weekday{
+	'U': time.Sunday,
+	'M': time.Monday,
+	'T': time.Tuesday,
+	'W': time.Wednesday,
+	'R': time.Thursday,
+	'F': time.Friday,
+	'S': time.Saturday,
+}
+
 type (
 	// Type to unmarshal link XML from UIUC CISAPI
 	Link struct {
+		ID   string `xml:"id,attr"`
 		Href string `xml:"href,attr"`
 	}
 
@@ -28,9 +58,16 @@ type (
 		Courses []Link `xml:"courses>course"`
 	}
 
+	// Type to unmarshal calendar year references from UIUC CISAPI
+	CalendarYear struct {
+		Year int `xml:"id,attr"`
+	}
+
 	// Type to unmarshal term XML from UIUC CISAPI
 	Term struct {
-		Subjects []Link `xml:"subjects>subject"`
+		Label    string       `xml:"label"`
+		Year     CalendarYear `xml:"parents>calendarYear"`
+		Subjects []Link       `xml:"subjects>subject"`
 	}
 
 	// Type to unmarshal subject XML from UIUC CISAPI
@@ -40,84 +77,502 @@ type (
 
 	// Type to unmarshal course XML from UIUC CISAPI.
 	Course struct {
-		Number           string          `xml:"id,attr"`
-		Name             string          `xml:"label"`
-		Subject          Subject         `xml:"parents>subject"`
-		Description      string          `xml:"description"`
-		CreditHours      string          `xml:"creditHours"`
-		DegreeAttributes string          `xml:"sectionDegreeAttributes"`
-		Sections         []types.Section `xml:"detailedSections>detailedSection"`
+		Number          string          `xml:"id,attr"`
+		Name            string          `xml:"label"`
+		Subject         Subject         `xml:"parents>subject"`
+		Description     string          `xml:"description"`
+		CreditHours     string          `xml:"creditHours"`
+		GenEdCategories []GenEdCategory `xml:"genEdCategories>category"`
+		Sections        []types.Section `xml:"detailedSections>detailedSection"`
+
+		// Deprecated prose form of GenEdCategories.
+		DegreeAttributes string `xml:"sectionDegreeAttributes"`
+	}
+
+	// Type to unmarshal GenEd category XML from UIUC CISAPI
+	GenEdCategory struct {
+		Code       string           `xml:"id,attr"`
+		Name       string           `xml:"description"`
+		Attributes []GenEdAttribute `xml:"genEdAttributes>genEdAttribute"`
+	}
+
+	// Type to unmarshal GenEd attribute XML from UIUC CISAPI
+	GenEdAttribute struct {
+		Code string `xml:"code,attr"`
+		Name string `xml:",chardata"`
+	}
+
+	// ErrorPolicy decides how a scrape reacts to documents it fails to fetch
+	// or digest.
+	ErrorPolicy struct {
+		// Stop the scrape at the first failure.
+		FailFast bool
+
+		// Skip failed documents, stopping once more than MaxErrors have
+		// failed. Zero skips any number of failures.
+		MaxErrors int
+	}
+
+	// Failure records a document a scrape failed to fetch or digest.
+	Failure struct {
+		URL string
+
+		// Department the document belongs to. Empty if the whole term is
+		// affected.
+		Department string
+
+		Err error
+	}
+
+	// Options controls a single DigestAll.
+	Options struct {
+		ErrorPolicy
+
+		// Progress of an earlier, interrupted scrape of the term. Subjects
+		// and courses it completed are skipped, and the caller records the
+		// courses it stores with Complete. Nil to scrape everything.
+		Resume *TermProgress
+
+		// Departments and courses to scrape. Others are skipped without
+		// being fetched.
+		Filter Filter
+
+		// Called with the scrape's progress every ProgressInterval and once
+		// more when it ends. Nil to report nothing.
+		OnProgress func(Progress)
+
+		// How often OnProgress is called. DefaultProgressInterval if zero.
+		ProgressInterval time.Duration
+	}
+
+	// CourseID identifies a course within a term.
+	CourseID struct {
+		Department string `json:"department"`
+		Number     int    `json:"number"`
+	}
+
+	// Report collects the failures skipped during a scrape.
+	Report struct {
+		// Term the scrape was of.
+		Term types.Term
+
+		Failures []Failure
+
+		// Courses skipped because they haven't changed since the last
+		// scrape. Only conditional scrapes skip courses.
+		Unmodified []CourseID
 	}
 )
 
+var (
+	// TooManyErrors is returned when a scrape skips more failures than its
+	// ErrorPolicy allows.
+	TooManyErrors = errors.New("Too many scrape errors")
+
+	// MalformedCourseError is returned when course XML lacks a usable id.
+	MalformedCourseError = errors.New("Malformed course id")
+)
+
+// Track the failures of a running scrape and whether it has been stopped.
+type digestion struct {
+	*Scraper
+
+	policy     ErrorPolicy
+	resume     *TermProgress
+	filter     Filter
+	courseChan chan types.Class
+
+	mu     sync.Mutex
+	report Report
+	err    error
+	status Progress
+	start  time.Time
+
+	// Canceled to stop all workers and their requests.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Record a failure, stopping the scrape if the policy says so. Returns true
+// if the scrape has been stopped. Failures caused by the scrape being
+// stopped aren't recorded.
+func (d *digestion) fail(url, department string, err error) bool {
+	d.forgetValidators(url)
+	if d.stopped() {
+		return true
+	}
+
+	log.WithFields(log.Fields{
+		"url":        url,
+		"department": department,
+	}).Warn("scrape failure: ", err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.report.Failures = append(d.report.Failures, Failure{url, department, err})
+
+	switch {
+	case d.policy.FailFast:
+		d.abort(err)
+	case d.policy.MaxErrors > 0 && len(d.report.Failures) > d.policy.MaxErrors:
+		d.abort(TooManyErrors)
+	}
+
+	return d.stopped()
+}
+
+// Stop the scrape with err unless it is already stopped. The caller must
+// hold the lock.
+func (d *digestion) abort(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.cancel()
+}
+
+// Return true if the scrape has been stopped, by policy or by the caller.
+func (d *digestion) stopped() bool {
+	return d.ctx.Err() != nil
+}
+
+// Record a course skipped because it hasn't changed.
+func (d *digestion) unmodified(course CourseID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.report.Unmodified = append(d.report.Unmodified, course)
+}
+
+// Send a digested class to the consumer unless the scrape is stopped.
+// Returns false if it was not sent.
+func (d *digestion) send(class types.Class) bool {
+	select {
+	case d.courseChan <- class:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
 // Digest ALL course data from the DB
 // Param: XMLData is list of departments
-func DigestAll(XMLData []byte, courseChan chan types.Class) {
+// Every class is sent on courseChan, which is closed when digestion is
+// complete. Failures are handled according to the options' policy: skipped
+// failures are collected in the returned Report, and an error is returned if
+// the scrape was stopped. Canceling ctx stops the scrape along with its
+// outstanding requests and returns the context's error.
+func (s *Scraper) DigestAll(ctx context.Context, XMLData []byte, courseChan chan types.Class, options Options) (Report, error) {
+	defer close(courseChan)
+
 	log.Debug("starting term digestion")
 	term := &Term{}
 	err := xml.Unmarshal(XMLData, term)
 	if err != nil {
-		log.Fatal("failed to unmarshal XML: ", err)
+		log.Error("failed to unmarshal XML: ", err)
+		return Report{}, err
+	}
+	classTerm := term.Term()
+
+	d := &digestion{
+		Scraper:    s,
+		policy:     options.ErrorPolicy,
+		resume:     options.Resume,
+		filter:     options.Filter,
+		courseChan: courseChan,
+		report:     Report{Term: classTerm},
+		status:     Progress{Term: classTerm},
+		start:      time.Now(),
+	}
+	d.ctx, d.cancel = context.WithCancel(ctx)
+	defer d.cancel()
+
+	var subjects []Link
+	for _, link := range term.Subjects {
+		if !d.filter.Subject(link.ID) {
+			continue
+		}
+		if d.resume.subjectDone(link.ID) {
+			log.Debug("skipping completed subject ", link.ID)
+			continue
+		}
+		subjects = append(subjects, link)
+	}
+	d.status.Subjects = len(subjects)
+
+	if options.OnProgress != nil {
+		interval := options.ProgressInterval
+		if interval <= 0 {
+			interval = DefaultProgressInterval
+		}
+
+		stop, done := make(chan struct{}), make(chan struct{})
+		go d.reportProgress(options.OnProgress, interval, stop, done)
+		defer func() {
+			close(stop)
+			<-done
+		}()
 	}
 
 	var wg sync.WaitGroup
 
-	for _, link := range term.Subjects {
-		data, err := GetXML(link.Href)
+	for _, link := range subjects {
+		if d.stopped() {
+			break
+		}
+
+		data, err := d.GetXML(d.ctx, link.Href)
 		if err != nil {
-			log.Fatal("error retrieving XML: ", err)
+			d.fail(link.Href, link.ID, err)
+			d.count(func(p *Progress) { p.SubjectsDone++ })
+			continue
 		}
 
 		wg.Add(1)
-		go digestDepartment(data, courseChan, &wg)
-		log.Info("started: ", link.Href)
+		go d.digestDepartment(data, link, classTerm, &wg)
+		log.WithField("subject", link.ID).Debug("started: ", link.Href)
 	}
 
 	wg.Wait()
 
-	close(courseChan)
-	log.Info("digestion complete")
+	log.WithFields(d.snapshot().Fields()).Info("digestion complete")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = ctx.Err()
+	}
+	return d.report, d.err
 }
 
-// Digest all courses from a given department
+// Digest all courses from a given department, tagging them with term.
 // Param: XMLData is list of courses for the department
-func digestDepartment(XMLData []byte, courseChan chan types.Class, wg *sync.WaitGroup) {
+func (d *digestion) digestDepartment(XMLData []byte, link Link, term types.Term, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer d.count(func(p *Progress) { p.SubjectsDone++ })
 
 	department := &Department{}
 	err := xml.Unmarshal(XMLData, department)
 	if err != nil {
-		log.Fatal("failed to unmarshal XML: ", err)
+		d.fail(link.Href, link.ID, err)
+		return
 	}
 
+	// Keep the courses the filter selects. A course without an id can only
+	// be selected along with its whole department.
+	var courses []Link
+	var ids []CourseID
 	for _, course := range department.Courses {
-		data, err := GetXML(course.Href + "?mode=detail")
+		id, hasID := courseID(link.ID, course)
+		if hasID && d.filter.Course(id) {
+			ids = append(ids, id)
+		} else if hasID || !d.filter.allOf(link.ID) {
+			continue
+		}
+		courses = append(courses, course)
+	}
+
+	// A subject can only be known complete if every course in it has an id.
+	if len(ids) == len(courses) {
+		d.resume.expect(link.ID, ids)
+	}
+
+	// Courses stored before the scrape was resumed are skipped.
+	var pending []Link
+	for _, course := range courses {
+		if id, hasID := courseID(link.ID, course); !hasID || !d.resume.courseDone(id) {
+			pending = append(pending, course)
+		}
+	}
+	d.count(func(p *Progress) {
+		p.SubjectsListed++
+		p.Courses += len(pending)
+	})
+
+	for _, course := range pending {
+		id, hasID := courseID(link.ID, course)
+
+		url := course.Href + "?mode=detail"
+		data, modified, err := d.getDocument(d.ctx, url)
 		if err != nil {
-			log.Fatal("error retrieving XML: ", err)
+			if d.failCourse(url, link.ID, err) {
+				return
+			}
+			continue
+		}
+
+		// Unchanged courses are still in the store from the last scrape.
+		if !modified && hasID {
+			d.unmodified(id)
+			d.resume.Complete(id)
+			d.count(func(p *Progress) { p.CoursesDone++ })
+			continue
 		}
 
 		c, err := digestClass(data)
 		if err != nil {
-			log.Fatal("failed to digest class: ", err)
+			if d.failCourse(url, link.ID, err) {
+				return
+			}
+			continue
 		}
+		c.Year = term.Year
+		c.Semester = term.Semester
+
+		if !d.send(*c) {
+			return
+		}
+		d.count(func(p *Progress) { p.CoursesDone++ })
+	}
+}
 
-		courseChan <- *c
+// Record a failed course like fail, counting it in the progress unless the
+// scrape was already stopped.
+func (d *digestion) failCourse(url, department string, err error) bool {
+	if !d.stopped() {
+		d.count(func(p *Progress) { p.CoursesFailed++ })
 	}
+	return d.fail(url, department, err)
 }
 
-// Extract credit hour numbers from course API string
+// Identify the course at link in department. Returns false if its id isn't a
+// course number.
+func courseID(department string, link Link) (CourseID, bool) {
+	number, err := strconv.Atoi(link.ID)
+	if err != nil {
+		return CourseID{}, false
+	}
+	return CourseID{department, number}, true
+}
+
+// Return the term identified by the term XML.
+func (t *Term) Term() types.Term {
+	return labelTerm(t.Year.Year, t.Label)
+}
+
+// Build the term in year from a label like "Spring 2016".
+func labelTerm(year int, label string) types.Term {
+	semester := ""
+	if fields := strings.Fields(label); len(fields) > 0 {
+		semester = strings.ToLower(fields[0])
+	}
+
+	return types.Term{Year: year, Semester: semester}
+}
+
+// Extract credit hour numbers from course API string, as a single number or
+// a range like "3-4"
 func normalizeCreditHours(str string) string {
+	min, max, ok := parseCreditHours(str)
+	if !ok {
+		log.Error("encountered unmatched credit hour string: ", str)
+		return ""
+	}
+
+	if min == max {
+		return formatCreditHours(min)
+	}
+	return formatCreditHours(min) + "-" + formatCreditHours(max)
+}
+
+// Extract the fewest and most credit hours a class can be taken for from a
+// course API string like "3 hours.", "3 OR 4 hours." or "1 TO 4 hours.".
+// Strings giving separate undergraduate and graduate hours, like "3
+// undergraduate hours. 4 graduate hours.", give the undergraduate hours.
+func parseCreditHours(str string) (min, max float64, ok bool) {
+	if i := strings.Index(strings.ToLower(str), "undergraduate"); i >= 0 {
+		str = str[:i]
+	}
+
 	matches := normalizeCreditHoursRE.FindAllString(str, -1)
+	for i, match := range matches {
+		hours, err := strconv.ParseFloat(strings.TrimRight(match, "."), 64)
+		if err != nil {
+			return 0, 0, false
+		}
+
+		if i == 0 || hours < min {
+			min = hours
+		}
+		if i == 0 || hours > max {
+			max = hours
+		}
+	}
+
+	return min, max, len(matches) > 0
+}
+
+func formatCreditHours(hours float64) string {
+	return strconv.FormatFloat(hours, 'f', -1, 64)
+}
+
+// Return the days in a course API string of day letters like "MWF".
+func parseDays(str string) types.Weekdays {
+	var days types.Weekdays
+	for _, letter := range str {
+		if day, ok := weekdayLetters[unicode.ToUpper(letter)]; ok {
+			days |= 1 << uint(day)
+		}
+	}
+	return days
+}
+
+// Return the minutes since midnight of a course API time like "09:00 AM", or
+// types.NoTime if it isn't a time, like "ARRANGED".
+func parseMeetingTime(str string) int {
+	t, err := time.Parse(meetingTimeLayout, strings.TrimSpace(str))
+	if err != nil {
+		return types.NoTime
+	}
+	return t.Hour()*60 + t.Minute()
+}
+
+// Remove whitespace from a section's fields and parse its dates, credit hours
+// and meeting times.
+func digestSection(section *types.Section) {
+	section.Code = strings.TrimSpace(section.Code)
+	section.Title = strings.TrimSpace(section.Title)
+	section.Text = strings.TrimSpace(section.Text)
+	section.Notes = strings.TrimSpace(section.Notes)
+	section.PartOfTermCode = strings.TrimSpace(section.PartOfTermCode)
+	section.StatusCode = strings.TrimSpace(section.StatusCode)
+	section.SpecialApproval = strings.TrimSpace(section.SpecialApproval)
+
+	section.StartDate = parseSectionDate(section.Start)
+	section.EndDate = parseSectionDate(section.End)
+	section.PartOfTerm = partOfTerm(section.PartOfTermCode)
+
+	if hours := strings.TrimSpace(section.CreditHours); hours != "" {
+		section.CreditHours = normalizeCreditHours(hours)
+		section.MinCreditHours, section.MaxCreditHours, _ = parseCreditHours(hours)
+	}
 
-	if len(matches) == 1 {
-		return matches[0]
+	for i := range section.Meetings {
+		meeting := &section.Meetings[i]
+		meeting.Days = strings.TrimSpace(meeting.Days)
+		meeting.Building = strings.TrimSpace(meeting.Building)
+		meeting.Room = strings.TrimSpace(meeting.Room)
+		meeting.Weekdays = parseDays(meeting.Days)
+		meeting.StartMinutes = parseMeetingTime(meeting.Start)
+		meeting.EndMinutes = parseMeetingTime(meeting.End)
 	}
-	if len(matches) == 2 {
-		return matches[0] + "-" + matches[1]
+}
+
+// Return the date in a course API string like "2016-01-19Z", or the zero
+// time if it isn't a date.
+func parseSectionDate(str string) time.Time {
+	date, err := time.Parse(sectionDateLayout, strings.TrimSpace(str))
+	if err != nil {
+		return time.Time{}
 	}
+	return date
+}
 
-	log.Error("encountered unmatched credit hour string: ", str)
-	return ""
+// Return the part of the term a section meets in from its course API part of
+// term code, or "" if the code isn't known. Dates alone can't tell: the
+// halves of a term depend on its calendar, which course XML doesn't give.
+func partOfTerm(code string) string {
+	return partOfTermCodes[code]
 }
 
 // Extract Degree Attributes from course API string
@@ -136,6 +591,30 @@ func normalizeDegreeAttributes(str string) []string {
 	return result
 }
 
+// Flatten GenEd categories from course API XML into the GenEds they stand
+// for, one per attribute. Categories without attributes stand for one GenEd
+// of their own.
+func normalizeGenEds(categories []GenEdCategory) []types.GenEd {
+	var genEds []types.GenEd
+	for _, category := range categories {
+		genEd := types.GenEd{
+			Category:     strings.TrimSpace(category.Code),
+			CategoryName: strings.TrimSpace(category.Name),
+		}
+		if len(category.Attributes) == 0 {
+			genEds = append(genEds, genEd)
+			continue
+		}
+
+		for _, attribute := range category.Attributes {
+			genEd.Code = strings.TrimSpace(attribute.Code)
+			genEd.Name = strings.TrimSpace(attribute.Name)
+			genEds = append(genEds, genEd)
+		}
+	}
+	return genEds
+}
+
 // Digest all sections from a given class
 // Param: XMLData is list of sections
 func digestClass(XMLData []byte) (*types.Class, error) {
@@ -146,25 +625,33 @@ func digestClass(XMLData []byte) (*types.Class, error) {
 		return nil, err
 	}
 
-	// Remove whitespace
-	for i, section := range course.Sections {
-		course.Sections[i].Code = strings.TrimSpace(section.Code)
-		for j, meeting := range section.Meetings {
-			course.Sections[i].Meetings[j].Days = strings.TrimSpace(meeting.Days)
-		}
+	// Remove whitespace and parse dates, credit hours and meeting times
+	for i := range course.Sections {
+		digestSection(&course.Sections[i])
 	}
 
-	courseNumber, _ := strconv.Atoi(strings.Split(course.Number, " ")[1])
+	// Course ids look like "CS 125"
+	id, err := ParseCourseID(course.Number)
+	if err != nil {
+		log.Error("malformed course id: ", course.Number)
+		return nil, err
+	}
+
+	minHours, maxHours, _ := parseCreditHours(course.CreditHours)
 
 	// Create Class struct
 	class := &types.Class{
-		Department:       course.Subject.Department,
-		CourseNumber:     courseNumber,
-		Name:             course.Name,
-		Description:      course.Description,
-		CreditHours:      normalizeCreditHours(course.CreditHours),
+		Department:     course.Subject.Department,
+		CourseNumber:   id.Number,
+		Name:           course.Name,
+		Description:    course.Description,
+		CreditHours:    normalizeCreditHours(course.CreditHours),
+		MinCreditHours: minHours,
+		MaxCreditHours: maxHours,
+		GenEds:         normalizeGenEds(course.GenEdCategories),
+		Sections:       course.Sections,
+
 		DegreeAttributes: normalizeDegreeAttributes(course.DegreeAttributes),
-		Sections:         course.Sections,
 	}
 
 	return class, nil
@@ -1,7 +1,17 @@
 package scrape
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/scheedule/coursestore/types"
 )
@@ -13,6 +23,13 @@ func emptyCheck(fieldname, str string, t *testing.T) {
 	}
 }
 
+// Error if the number is zero
+func zeroCheck(fieldname string, n int, t *testing.T) {
+	if n == 0 {
+		t.Errorf("Field %s zero when it shouldn't be", fieldname)
+	}
+}
+
 // Error if the instructor contains any empty strings
 func instructorEmptyCheck(instructor types.Instructor, t *testing.T) {
 	emptyCheck("FirstName", instructor.FirstName, t)
@@ -30,6 +47,12 @@ func meetingEmptyCheck(meeting types.Meeting, t *testing.T) {
 	emptyCheck("Days", meeting.Days, t)
 	emptyCheck("End", meeting.End, t)
 	emptyCheck("Start", meeting.Start, t)
+	if !meeting.Timed() {
+		t.Errorf("Meeting from %q to %q has no time", meeting.Start, meeting.End)
+	}
+	zeroCheck("Weekdays", int(meeting.Weekdays), t)
+	emptyCheck("Building", meeting.Building, t)
+	emptyCheck("Room", meeting.Room, t)
 	courseTypeEmptyCheck(meeting.Type, t)
 	for i := range meeting.Instructors {
 		instructorEmptyCheck(meeting.Instructors[i], t)
@@ -38,7 +61,7 @@ func meetingEmptyCheck(meeting types.Meeting, t *testing.T) {
 
 // Error if the section contains any empty strings
 func sectionEmptyCheck(section types.Section, t *testing.T) {
-	emptyCheck("CRN", section.CRN, t)
+	zeroCheck("CRN", section.CRN, t)
 	emptyCheck("Code", section.Code, t)
 	for i := range section.Meetings {
 		meetingEmptyCheck(section.Meetings[i], t)
@@ -47,12 +70,12 @@ func sectionEmptyCheck(section types.Section, t *testing.T) {
 
 // Error if the class contains any empty strings
 func classEmptyCheck(class types.Class, t *testing.T) {
-	emptyCheck("CourseNumber", class.CourseNumber, t)
+	zeroCheck("CourseNumber", class.CourseNumber, t)
 	emptyCheck("Department", class.Department, t)
 	emptyCheck("Name", class.Name, t)
 	emptyCheck("Description", class.Description, t)
 	emptyCheck("CreditHours", class.CreditHours, t)
-	//emptyCheck("DegreeAttributes", class.DegreeAttributes, t)
+	zeroCheck("MaxCreditHours", int(class.MaxCreditHours), t)
 	for i := range class.Sections {
 		sectionEmptyCheck(class.Sections[i], t)
 	}
@@ -60,36 +83,362 @@ func classEmptyCheck(class types.Class, t *testing.T) {
 
 // Error if we can't retrieve an XML document
 func TestGetXML(t *testing.T) {
-	url := "http://courses.illinois.edu/cisapp/explorer/schedule/2016.xml"
-	_, err := GetXML(url)
+	server := testServer(t)
+	defer server.Close()
+
+	header := http.Header{"User-Agent": []string{"coursestore-test"}}
+	var userAgent string
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		userAgent = r.UserAgent()
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	s := New(Config{Client: client, Header: header})
+	data, err := s.GetXML(context.Background(), server.URL+"/schedule/2016/spring.xml")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("Spring 2016")) {
+		t.Error("GetXML returned unexpected document: ", string(data))
+	}
+	if userAgent != "coursestore-test" {
+		t.Errorf("request sent with User-Agent %q, want the configured header", userAgent)
 	}
 }
 
-// Error if we can't retrieve an XML document. Testing the limiter is dificult,
-// so let's just make sure it gets to the function.
+// Error if more requests than the concurrency limit are in flight at once
 func TestGetXMLLimiter(t *testing.T) {
-	url := "http://courses.illinois.edu/cisapp/explorer/schedule/2016.xml"
-	_, err := GetXML(url)
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write([]byte("<ok/>"))
+	}))
+	defer server.Close()
+
+	s := New(Config{Concurrency: 2})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.GetXML(context.Background(), server.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if most > 2 {
+		t.Errorf("%d requests in flight at once, want at most %d", most, 2)
+	}
+}
+
+// Error if requests start faster than the rate limit
+func TestGetXMLRate(t *testing.T) {
+	server, _ := statusServer()
+	defer server.Close()
+
+	s := New(Config{Rate: 100, Burst: 1})
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := s.GetXML(context.Background(), server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 requests at 100 per second took %v, want at least %v", elapsed, 40*time.Millisecond)
+	}
+}
+
+// Adapt a function to an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// Serve the documents in testdata the way the CISAPI does, with SERVER in
+// them replaced by the server's URL. Documents are tagged with an ETag, and
+// conditional requests are answered with 304 Not Modified.
+func testServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", filepath.FromSlash(r.URL.Path)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data = bytes.Replace(data, []byte("SERVER"), []byte(server.URL), -1)
+
+		w.Header().Set("Content-Type", "application/xml")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(data)))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+
+	return server
+}
+
+// Digest the test term with policy, returning the classes sent.
+func digestTestTerm(t *testing.T, ctx context.Context, policy ErrorPolicy) ([]types.Class, Report, error) {
+	server := testServer(t)
+	defer server.Close()
+
+	return digestTerm(t, ctx, New(Config{Retries: testRetries}), server.URL+"/schedule/2016/spring.xml",
+		Options{ErrorPolicy: policy})
+}
+
+// Digest the term at url with s, returning the classes sent.
+func digestTerm(t *testing.T, ctx context.Context, s *Scraper, url string, options Options) ([]types.Class, Report, error) {
+	data, err := s.GetXML(context.Background(), url)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+
+	courseChan := make(chan types.Class)
+	var classes []types.Class
+	done := make(chan struct{})
+	go func() {
+		for class := range courseChan {
+			classes = append(classes, class)
+		}
+		close(done)
+	}()
+
+	report, err := s.DigestAll(ctx, data, courseChan, options)
+	<-done
+
+	return classes, report, err
 }
 
 // Error if we fail to parse class data
 func TestDigestClass(t *testing.T) {
-	url := "http://courses.illinois.edu/cisapp/explorer/schedule/2016/spring/" +
-		"AAS/100.xml?mode=detail"
-	data, err := GetXML(url)
+	server := testServer(t)
+	defer server.Close()
+
+	data, err := New(Config{}).GetXML(context.Background(), server.URL+"/schedule/2016/spring/CS/125.xml?mode=detail")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
 	class, err := digestClass(data)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
 	classEmptyCheck(*class, t)
+
+	genEd := types.GenEd{Category: "QR", CategoryName: "Quantitative Reasoning", Code: "1QR1", Name: "Quantitative Reasoning I"}
+	if len(class.GenEds) != 1 || class.GenEds[0] != genEd {
+		t.Errorf("digestClass GenEds %+v, want [%+v]", class.GenEds, genEd)
+	}
+	if fmt.Sprint(class.DegreeAttributes) != "[Quantitative Reasoning I]" {
+		t.Errorf("digestClass DegreeAttributes %q, want [Quantitative Reasoning I]", class.DegreeAttributes)
+	}
+
+	section := class.Sections[0]
+	want := types.Section{
+		Title:           "Intro to Computer Science",
+		Text:            "Lecture meets with a lab section.",
+		Notes:           "Restricted to majors until registration opens to all.",
+		PartOfTermCode:  "1",
+		PartOfTerm:      types.FullTerm,
+		StatusCode:      "A",
+		SpecialApproval: "Departmental Approval Required",
+		CreditHours:     "3-4",
+		MinCreditHours:  3,
+		MaxCreditHours:  4,
+	}
+	if section.Title != want.Title || section.Text != want.Text || section.Notes != want.Notes ||
+		section.PartOfTermCode != want.PartOfTermCode || section.PartOfTerm != want.PartOfTerm ||
+		section.StatusCode != want.StatusCode || section.SpecialApproval != want.SpecialApproval ||
+		section.CreditHours != want.CreditHours || section.MinCreditHours != want.MinCreditHours ||
+		section.MaxCreditHours != want.MaxCreditHours {
+		t.Errorf("digestClass section fields %+v, want %+v", section, want)
+	}
+	if class.Sections[1].CreditHours != "" {
+		t.Errorf("section without credit hours has %q, want none", class.Sections[1].CreditHours)
+	}
+	if class.Sections[1].PartOfTerm != "" {
+		t.Errorf("section without a part of term code is in %q, want none", class.Sections[1].PartOfTerm)
+	}
+}
+
+var parseCreditHoursTests = []struct {
+	in       string
+	min, max float64
+	ok       bool
+	out      string
+}{
+	{"3 hours.", 3, 3, true, "3"},
+	{"3 OR 4 hours.", 3, 4, true, "3-4"},
+	{"1 TO 4 hours.", 1, 4, true, "1-4"},
+	{"0.5 hours.", 0.5, 0.5, true, "0.5"},
+	{"2 TO 4 undergraduate hours. 2 TO 8 graduate hours.", 2, 4, true, "2-4"},
+	{"3 undergraduate hours. 4 graduate hours.", 3, 3, true, "3"},
+	{"4 graduate hours.", 4, 4, true, "4"},
+	{"Variable hours.", 0, 0, false, ""},
+}
+
+func TestParseCreditHours(t *testing.T) {
+	for _, tt := range parseCreditHoursTests {
+		min, max, ok := parseCreditHours(tt.in)
+		if min != tt.min || max != tt.max || ok != tt.ok {
+			t.Errorf("parseCreditHours(%q) => %g, %g, %t, want %g, %g, %t", tt.in, min, max, ok, tt.min, tt.max, tt.ok)
+		}
+		if out := normalizeCreditHours(tt.in); out != tt.out {
+			t.Errorf("normalizeCreditHours(%q) => %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+var partOfTermTests = []struct {
+	code string
+	out  string
+}{
+	{"1", types.FullTerm},
+	{"A", types.FirstEightWeeks},
+	{"B", types.SecondEightWeeks},
+	{"", ""},
+	{"Z", ""},
+}
+
+func TestPartOfTerm(t *testing.T) {
+	for _, tt := range partOfTermTests {
+		if out := partOfTerm(tt.code); out != tt.out {
+			t.Errorf("partOfTerm(%q) => %q, want %q", tt.code, out, tt.out)
+		}
+	}
+}
+
+var parseDaysTests = []struct {
+	in  string
+	out []time.Weekday
+}{
+	{"MWF", []time.Weekday{time.Monday, time.Wednesday, time.Friday}},
+	{"TR", []time.Weekday{time.Tuesday, time.Thursday}},
+	{"su", []time.Weekday{time.Sunday, time.Saturday}},
+	{"", nil},
+}
+
+func TestParseDays(t *testing.T) {
+	for _, tt := range parseDaysTests {
+		days := parseDays(tt.in).List()
+		if fmt.Sprint(days) != fmt.Sprint(tt.out) {
+			t.Errorf("parseDays(%q) => %v, want %v", tt.in, days, tt.out)
+		}
+	}
+}
+
+var parseMeetingTimeTests = []struct {
+	in  string
+	out int
+}{
+	{"09:00 AM", 540},
+	{"12:30 PM", 750},
+	{"04:50 PM", 1010},
+	{" 12:00 AM ", 0},
+	{"ARRANGED", types.NoTime},
+	{"", types.NoTime},
+}
+
+func TestParseMeetingTime(t *testing.T) {
+	for _, tt := range parseMeetingTimeTests {
+		if minutes := parseMeetingTime(tt.in); minutes != tt.out {
+			t.Errorf("parseMeetingTime(%q) => %d, want %d", tt.in, minutes, tt.out)
+		}
+	}
+}
+
+// Error if malformed course XML digests without error
+func TestDigestClassMalformed(t *testing.T) {
+	server := testServer(t)
+	defer server.Close()
+
+	data, err := New(Config{}).GetXML(context.Background(), server.URL+"/schedule/2016/spring/CS/999.xml?mode=detail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = digestClass(data); err != MalformedCourseError {
+		t.Errorf("digestClass returned %v, want %v", err, MalformedCourseError)
+	}
+}
+
+// Error if failures aren't skipped and reported
+func TestDigestAllSkipsFailures(t *testing.T) {
+	classes, report, err := digestTestTerm(t, context.Background(), ErrorPolicy{})
+	if err != nil {
+		t.Fatal("DigestAll returned error: ", err)
+	}
+
+	if len(classes) != 2 {
+		t.Errorf("DigestAll sent %d classes, want %d", len(classes), 2)
+	}
+	for _, class := range classes {
+		if class.Year != 2016 || class.Semester != "spring" {
+			t.Errorf("class tagged with %v, want spring 2016", class.Term())
+		}
+	}
+
+	if len(report.Failures) != 1 {
+		t.Fatalf("report has %d failures, want %d", len(report.Failures), 1)
+	}
+	if failure := report.Failures[0]; failure.Department != "CS" || failure.Err != MalformedCourseError {
+		t.Errorf("unexpected failure: %+v", failure)
+	}
+}
+
+// Error if the error policy doesn't stop the scrape
+func TestDigestAllStops(t *testing.T) {
+	server := testServer(t)
+	defer server.Close()
+
+	// The spring term has one failed course. The summer term has it and two
+	// missing subjects.
+	policies := []struct {
+		term   string
+		policy ErrorPolicy
+		err    error
+	}{
+		{"spring", ErrorPolicy{FailFast: true}, MalformedCourseError},
+		{"spring", ErrorPolicy{MaxErrors: 1}, nil},
+		{"summer", ErrorPolicy{MaxErrors: 1}, TooManyErrors},
+		{"summer", ErrorPolicy{MaxErrors: 3}, nil},
+	}
+
+	for _, tt := range policies {
+		_, _, err := digestTerm(t, context.Background(), New(Config{Retries: testRetries}),
+			server.URL+"/schedule/2016/"+tt.term+".xml", Options{ErrorPolicy: tt.policy})
+		if err != tt.err {
+			t.Errorf("DigestAll of %s with %+v returned %v, want %v", tt.term, tt.policy, err, tt.err)
+		}
+	}
+}
+
+// Error if a canceled scrape sends classes or doesn't return the context's
+// error
+func TestDigestAllCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	classes, report, err := digestTestTerm(t, ctx, ErrorPolicy{})
+	if err != context.Canceled {
+		t.Errorf("DigestAll returned %v, want %v", err, context.Canceled)
+	}
+	if len(classes) != 0 || len(report.Failures) != 0 {
+		t.Errorf("canceled DigestAll sent %d classes and %d failures", len(classes), len(report.Failures))
+	}
 }
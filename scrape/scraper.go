@@ -0,0 +1,175 @@
+package scrape
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// DefaultConcurrency is the number of requests a Scraper makes at once unless
+// configured otherwise.
+const DefaultConcurrency = 20
+
+type (
+	// Config configures how a Scraper talks to the course API. The zero value
+	// is usable.
+	Config struct {
+		// Client used for every request. http.DefaultClient if nil.
+		Client *http.Client
+
+		// Most requests in flight at once. DefaultConcurrency if zero.
+		Concurrency int
+
+		// Most requests started per second. Zero for no limit. The rate is
+		// lowered while the course API responds with 429 or 503.
+		Rate float64
+
+		// Most requests started at once after a lull. One second's worth of
+		// Rate if zero.
+		Burst int
+
+		// Headers added to every request, such as User-Agent.
+		Header http.Header
+
+		// How failed requests are retried. DefaultRetries if MaxAttempts is
+		// zero.
+		Retries RetryPolicy
+
+		// Directory every fetched document is saved to, keyed by URL. Empty
+		// to keep nothing.
+		CacheDir string
+
+		// Read documents only from CacheDir instead of the course API.
+		// Documents missing from the cache fail with NotCached.
+		Offline bool
+
+		// Make requests conditional on documents having changed since the
+		// last scrape saved their validators, skipping courses that haven't.
+		// Needs CacheDir and Validators.
+		Conditional bool
+
+		// Where the validators of fetched documents are kept between
+		// scrapes, normally the store the classes are scraped into.
+		Validators ValidatorStore
+	}
+
+	// ValidatorStore keeps the validators of the documents behind the
+	// classes in a store, saved by some version of the parser.
+	ValidatorStore interface {
+		Validators(version int) (map[string]types.DocumentValidators, error)
+		PutValidators(version int, all map[string]types.DocumentValidators) error
+	}
+
+	// FetchStats tallies the requests a Scraper made to the course API.
+	FetchStats struct {
+		// Requests made, including retries and ones that got no response.
+		Requests int
+
+		// Responses received by status code.
+		Statuses map[int]int
+
+		// Bytes of response bodies read.
+		Bytes int64
+	}
+
+	// Scraper fetches and digests documents from the course API. It is safe
+	// for concurrent use.
+	Scraper struct {
+		// Requests in flight. Accessed atomically, so it comes first to be
+		// aligned.
+		inFlight int64
+
+		client  *http.Client
+		header  http.Header
+		retries RetryPolicy
+
+		// Semaphore limiting the number of concurrent connections.
+		sem chan empty
+
+		// Limits how often requests start. Nil if there is no limit.
+		limit *limiter
+
+		// Where fetched documents are saved. Nil if they aren't.
+		cache   *cache
+		offline bool
+
+		// Validators saved by the last scrape, loaded on first use, and ones
+		// received since.
+		conditional    bool
+		validators     ValidatorStore
+		validatorsOnce sync.Once
+		mu             sync.Mutex
+		saved          map[string]types.DocumentValidators
+		pending        map[string]types.DocumentValidators
+
+		statsMu sync.Mutex
+		stats   FetchStats
+	}
+)
+
+// Construct a new Scraper from config, filling in defaults.
+func New(config Config) *Scraper {
+	s := &Scraper{
+		client:  config.Client,
+		header:  config.Header,
+		retries: config.Retries,
+		limit:   newLimiter(config.Rate, config.Burst),
+		offline: config.Offline,
+		stats:   FetchStats{Statuses: make(map[int]int)},
+	}
+
+	if config.CacheDir != "" {
+		s.cache = &cache{dir: config.CacheDir}
+		s.conditional = config.Conditional && !config.Offline && config.Validators != nil
+		s.validators = config.Validators
+		s.pending = make(map[string]types.DocumentValidators)
+	}
+
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if s.retries.MaxAttempts == 0 {
+		s.retries = DefaultRetries
+	}
+
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	s.sem = make(chan empty, concurrency)
+
+	return s
+}
+
+// Return the number of requests the scraper has in flight.
+func (s *Scraper) InFlight() int {
+	return int(atomic.LoadInt64(&s.inFlight))
+}
+
+// Return the requests the scraper has made so far.
+func (s *Scraper) Stats() FetchStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	stats := s.stats
+	stats.Statuses = make(map[int]int, len(s.stats.Statuses))
+	for status, n := range s.stats.Statuses {
+		stats.Statuses[status] = n
+	}
+	return stats
+}
+
+// Tally a request, its response's status code if there was a response, and
+// the bytes of its body read.
+func (s *Scraper) tally(resp *http.Response, bytes int) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.stats.Requests++
+	if resp != nil {
+		s.stats.Statuses[resp.StatusCode]++
+	}
+	s.stats.Bytes += int64(bytes)
+}
@@ -0,0 +1,82 @@
+package scrape
+
+import (
+	"context"
+	"encoding/xml"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// ScheduleURL is the root of the UIUC CISAPI schedule, listing every calendar
+// year with classes.
+const ScheduleURL = "http://courses.illinois.edu/cisapp/explorer/schedule.xml"
+
+type (
+	// Type to unmarshal schedule XML from UIUC CISAPI
+	Schedule struct {
+		Years []Link `xml:"calendarYears>calendarYear"`
+	}
+
+	// Type to unmarshal term references from UIUC CISAPI
+	TermLink struct {
+		Href  string `xml:"href,attr"`
+		Label string `xml:",chardata"`
+	}
+
+	// Type to unmarshal calendar year XML from UIUC CISAPI
+	Year struct {
+		Year  int        `xml:"id,attr"`
+		Terms []TermLink `xml:"terms>term"`
+	}
+
+	// AvailableTerm is a term offered by the CISAPI along with the URL of its
+	// term XML.
+	AvailableTerm struct {
+		types.Term
+		URL string
+	}
+)
+
+// List every term available from the schedule at scheduleURL, oldest first.
+func (s *Scraper) ListTerms(ctx context.Context, scheduleURL string) ([]AvailableTerm, error) {
+	data, err := s.GetXML(ctx, scheduleURL)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &Schedule{}
+	if err = xml.Unmarshal(data, schedule); err != nil {
+		log.Error("failed to unmarshal schedule XML: ", err)
+		return nil, err
+	}
+
+	var terms []AvailableTerm
+	for _, link := range schedule.Years {
+		data, err := s.GetXML(ctx, link.Href)
+		if err != nil {
+			return nil, err
+		}
+
+		year := &Year{}
+		if err = xml.Unmarshal(data, year); err != nil {
+			log.Error("failed to unmarshal calendar year XML: ", err)
+			return nil, err
+		}
+
+		for _, term := range year.Terms {
+			terms = append(terms, AvailableTerm{
+				Term: labelTerm(year.Year, term.Label),
+				URL:  term.Href,
+			})
+		}
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i].Before(terms[j].Term)
+	})
+
+	return terms, nil
+}
@@ -0,0 +1,68 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// Serve a schedule with two calendar years, listed newest first.
+func scheduleServer() *httptest.Server {
+	mux := http.NewServeMux()
+	var server *httptest.Server
+
+	mux.HandleFunc("/schedule.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<ns2:schedule xmlns:ns2="http://rest.cis.illinois.edu">
+<calendarYears>
+<calendarYear id="2016" href="%[1]s/schedule/2016.xml">2016</calendarYear>
+<calendarYear id="2015" href="%[1]s/schedule/2015.xml">2015</calendarYear>
+</calendarYears></ns2:schedule>`, server.URL)
+	})
+	mux.HandleFunc("/schedule/2016.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<ns2:calendarYear xmlns:ns2="http://rest.cis.illinois.edu" id="2016">
+<label>2016</label><terms>
+<term id="120168" href="%[1]s/schedule/2016/fall.xml">Fall 2016</term>
+<term id="120161" href="%[1]s/schedule/2016/spring.xml">Spring 2016</term>
+</terms></ns2:calendarYear>`, server.URL)
+	})
+	mux.HandleFunc("/schedule/2015.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<ns2:calendarYear xmlns:ns2="http://rest.cis.illinois.edu" id="2015">
+<label>2015</label><terms>
+<term id="120158" href="%[1]s/schedule/2015/fall.xml">Fall 2015</term>
+</terms></ns2:calendarYear>`, server.URL)
+	})
+
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func TestListTerms(t *testing.T) {
+	server := scheduleServer()
+	defer server.Close()
+
+	terms, err := New(Config{}).ListTerms(context.Background(), server.URL+"/schedule.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.Term{
+		{Year: 2015, Semester: "fall"},
+		{Year: 2016, Semester: "spring"},
+		{Year: 2016, Semester: "fall"},
+	}
+	if len(terms) != len(want) {
+		t.Fatalf("ListTerms returned %d terms, want %d", len(terms), len(want))
+	}
+	for i := range want {
+		if terms[i].Term != want[i] {
+			t.Errorf("term %d is %v, want %v", i, terms[i].Term, want[i])
+		}
+	}
+	if terms[2].URL != server.URL+"/schedule/2016/fall.xml" {
+		t.Errorf("term URL is %q, want the fall 2016 term XML", terms[2].URL)
+	}
+}
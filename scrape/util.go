@@ -1,59 +1,287 @@
 package scrape
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+
+	"github.com/scheedule/coursestore/types"
 )
 
 type empty struct{}
 
-var sem chan empty
+type (
+	// RetryPolicy bounds how requests to the course API are retried. Network
+	// errors, 5xx responses and 429 responses are retried; other responses
+	// are not.
+	RetryPolicy struct {
+		// Most requests made for one URL, including the first.
+		MaxAttempts int
+
+		// Delay before the first retry. It doubles for every retry after.
+		BaseDelay time.Duration
+
+		// Longest delay between attempts, including ones asked for with a
+		// Retry-After header.
+		MaxDelay time.Duration
+	}
+
+	// FetchError is returned when a document can't be fetched from the course
+	// API, either because the response can't be retried or because every
+	// attempt failed.
+	FetchError struct {
+		URL string
+
+		// Status code of the last response. Zero if no response was received.
+		StatusCode int
+
+		// Requests made before giving up.
+		Attempts int
+
+		// Error of the last attempt if no response was received.
+		Err error
+	}
+)
+
+// DefaultRetries is the RetryPolicy used by a Scraper unless configured
+// otherwise.
+var DefaultRetries = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+func (e *FetchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("fetching %s failed after %d attempts: %v", e.URL, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("fetching %s failed after %d attempts: status %d", e.URL, e.Attempts, e.StatusCode)
+}
+
+// Return true if a response with the status code should be retried.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// Return true if a response with the status code means the course API wants
+// fewer requests.
+func overloaded(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// Return the delay before retry number attempt, doubling from the base delay
+// with up to half of it randomized so clients don't retry in lockstep.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << uint(attempt-1)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
 
-// Initialize semaphore
-func init() {
-	sem = make(chan empty, 20)
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Parse a Retry-After header, given either in seconds or as an HTTP date.
+// Returns false if there is no usable header.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		delay := date.Sub(time.Now())
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
 }
 
 // Process requests to course API. Use semaphore to limit the number of
-// concurrent connections to the API.
-func GetXML(url string) ([]byte, error) {
+// concurrent connections to the API. Canceling ctx abandons the request,
+// returning the context's error. Fetched documents are saved to the cache,
+// and an offline scraper reads them from there instead.
+func (s *Scraper) GetXML(ctx context.Context, url string) ([]byte, error) {
+	data, _, err := s.getDocument(ctx, url)
+	return data, err
+}
 
+// Fetch the document at url like GetXML. modified is false if the course API
+// reported the cached copy is current, in which case that copy is returned.
+func (s *Scraper) getDocument(ctx context.Context, url string) (data []byte, modified bool, err error) {
+	if s.offline {
+		if s.cache == nil {
+			return nil, true, NotCached
+		}
+		data, err = s.cache.get(url)
+		return data, true, err
+	}
+
+	saved := s.savedValidators(url)
+	data, resp, err := s.getXML(ctx, url, saved)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		if s.cache != nil {
+			if data, err = s.cache.get(url); err == nil {
+				s.keepValidators(url, saved)
+				return data, false, nil
+			}
+		}
+
+		log.Warn("no usable copy of unmodified ", url, ", fetching it again")
+		if data, resp, err = s.getXML(ctx, url, types.DocumentValidators{}); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if s.cache != nil {
+		if err := s.cache.put(url, data); err != nil {
+			log.Warn("failed to cache ", url, ": ", err)
+		} else {
+			s.keepValidators(url, responseValidators(resp))
+		}
+	}
+
+	return data, true, nil
+}
+
+// Make request to url and return XML at that url, retrying according to the
+// scraper's policy. The request is conditional on v, so the last response
+// may be 304 Not Modified with no body. A *FetchError is returned once the
+// policy gives up.
+func (s *Scraper) getXML(ctx context.Context, url string, v types.DocumentValidators) ([]byte, *http.Response, error) {
+	policy := s.retries
+	fetchErr := &FetchError{URL: url}
+
+	for {
+		fetchErr.Attempts++
+		body, resp, err := s.fetch(ctx, url, v)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err == nil {
+			s.limit.speedUp()
+			return body, resp, nil
+		}
+		if resp != nil && overloaded(resp.StatusCode) {
+			s.limit.slowDown()
+		}
+
+		fetchErr.Err = nil
+		fetchErr.StatusCode = 0
+		if resp != nil {
+			fetchErr.StatusCode = resp.StatusCode
+		} else {
+			fetchErr.Err = err
+		}
+
+		if resp != nil && !retryable(resp.StatusCode) {
+			log.Warn("not retrying ", url, ": received ", resp.StatusCode)
+			return nil, nil, fetchErr
+		}
+		if fetchErr.Attempts >= policy.MaxAttempts {
+			log.Error("giving up on ", url, ": ", err)
+			return nil, nil, fetchErr
+		}
+
+		delay := policy.backoff(fetchErr.Attempts)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+				if delay > policy.MaxDelay {
+					delay = policy.MaxDelay
+				}
+			}
+		}
+
+		log.Warn("retrying ", url, " in ", delay, ": ", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// Make a single request to url conditional on v once a connection is free
+// and the rate limit allows it. The connection is only held for the request,
+// so retries waiting out their backoff leave it to others. The response is
+// returned along with an error for any status other than 200 OK or 304 Not
+// Modified.
+func (s *Scraper) fetch(ctx context.Context, url string, v types.DocumentValidators) ([]byte, *http.Response, error) {
 	// Acquire
 	e := empty{}
-	sem <- e
+	select {
+	case s.sem <- e:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
 
 	defer func() {
-		<-sem
+		<-s.sem
 	}()
 
-	return getXML(url)
-}
+	atomic.AddInt64(&s.inFlight, 1)
+	defer atomic.AddInt64(&s.inFlight, -1)
 
-// Make request to url and return XML at that url.
-func getXML(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+	if err := s.limit.wait(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, values := range s.header {
+		req.Header[key] = values
+	}
+	applyValidators(req, v)
+
+	resp, err := s.client.Do(req.WithContext(ctx))
 	if err != nil {
 		log.Error("HTTP GET Failed:", err)
-		return nil, err
+		s.tally(nil, 0)
+		return nil, nil, err
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		log.Warn("oops! recieved: ", resp.StatusCode)
-		time.Sleep(2 * time.Second)
-		resp.Body.Close()
-		return getXML(url)
+	if resp.StatusCode == http.StatusNotModified {
+		s.tally(resp, 0)
+		return nil, resp, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		s.tally(resp, 0)
+		return nil, resp, fmt.Errorf("received status %d", resp.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
+	s.tally(resp, len(body))
 	if err != nil {
 		log.Error("failed to read response body: ", err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	return body, nil
+	return body, resp, nil
 }
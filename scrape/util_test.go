@@ -0,0 +1,168 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Retry quickly in tests.
+var testRetries = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    10 * time.Millisecond,
+}
+
+// Serve the statuses in order, then 200 OK, counting requests.
+func statusServer(statuses ...int) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= len(statuses) {
+			if statuses[requests-1] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0")
+			}
+			w.WriteHeader(statuses[requests-1])
+			return
+		}
+		w.Write([]byte("<ok/>"))
+	}))
+
+	return server, &requests
+}
+
+var retryTests = []struct {
+	statuses []int
+	requests int
+	status   int
+}{
+	{[]int{}, 1, 0},
+	{[]int{503, 500}, 3, 0},
+	{[]int{429}, 2, 0},
+	{[]int{404}, 1, 404},
+	{[]int{503, 503, 503}, 3, 503},
+}
+
+func TestGetXMLRetries(t *testing.T) {
+	for _, tt := range retryTests {
+		server, requests := statusServer(tt.statuses...)
+
+		_, err := New(Config{Retries: testRetries}).GetXML(context.Background(), server.URL)
+		server.Close()
+
+		if *requests != tt.requests {
+			t.Errorf("statuses %v: made %d requests, want %d", tt.statuses, *requests, tt.requests)
+		}
+
+		if tt.status == 0 {
+			if err != nil {
+				t.Errorf("statuses %v: returned error %v", tt.statuses, err)
+			}
+			continue
+		}
+
+		fetchErr, ok := err.(*FetchError)
+		if !ok {
+			t.Fatalf("statuses %v: returned %v, want a *FetchError", tt.statuses, err)
+		}
+		if fetchErr.StatusCode != tt.status || fetchErr.Attempts != tt.requests {
+			t.Errorf("statuses %v: returned %+v", tt.statuses, fetchErr)
+		}
+	}
+}
+
+func TestGetXMLNetworkError(t *testing.T) {
+	server, _ := statusServer()
+	url := server.URL
+	server.Close()
+
+	_, err := New(Config{Retries: testRetries}).GetXML(context.Background(), url)
+	fetchErr, ok := err.(*FetchError)
+	if !ok || fetchErr.Err == nil || fetchErr.Attempts != testRetries.MaxAttempts {
+		t.Errorf("GetXML of closed server returned %v, want a *FetchError after %d attempts",
+			err, testRetries.MaxAttempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt < 10; attempt++ {
+		delay := testRetries.backoff(attempt)
+		if delay <= 0 || delay > testRetries.MaxDelay {
+			t.Errorf("backoff(%d) = %v, want within (0, %v]", attempt, delay, testRetries.MaxDelay)
+		}
+	}
+}
+
+func TestGetXMLCanceled(t *testing.T) {
+	server, requests := statusServer(503, 503)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := New(Config{Retries: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}})
+	_, err := s.GetXML(ctx, server.URL)
+	if err != context.Canceled {
+		t.Errorf("GetXML with canceled context returned %v, want %v", err, context.Canceled)
+	}
+	if *requests != 0 {
+		t.Errorf("GetXML with canceled context made %d requests", *requests)
+	}
+}
+
+func TestGetXMLBackoffFreesConnection(t *testing.T) {
+	failed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/retried" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			close(failed)
+			return
+		}
+		w.Write([]byte("<ok/>"))
+	}))
+	defer server.Close()
+
+	s := New(Config{Concurrency: 1, Retries: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.GetXML(ctx, server.URL+"/retried")
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	<-failed
+
+	// The retried request waits out its backoff without the only connection.
+	other, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	if _, err := s.GetXML(other, server.URL+"/other"); err != nil {
+		t.Error("GetXML during another request's backoff returned error: ", err)
+	}
+	if n := s.InFlight(); n != 0 {
+		t.Errorf("%d requests in flight during backoff, want 0", n)
+	}
+}
+
+func TestScraperStats(t *testing.T) {
+	server, _ := statusServer(503)
+	defer server.Close()
+
+	s := New(Config{Retries: testRetries})
+	if _, err := s.GetXML(context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := s.Stats()
+	if stats.Requests != 2 || stats.Statuses[503] != 1 || stats.Statuses[200] != 1 {
+		t.Errorf("stats after a retried request are %+v", stats)
+	}
+	if stats.Bytes != int64(len("<ok/>")) {
+		t.Errorf("stats count %d bytes fetched, want %d", stats.Bytes, len("<ok/>"))
+	}
+}
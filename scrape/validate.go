@@ -0,0 +1,191 @@
+package scrape
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// Checks a Validator makes of each class.
+const (
+	// The class has no name.
+	EmptyName = "empty_name"
+
+	// The class has no course number.
+	ZeroCourseNumber = "zero_course_number"
+
+	// The class's credit hours couldn't be parsed.
+	BadCreditHours = "bad_credit_hours"
+
+	// A section of the class has no meetings.
+	NoMeetings = "no_meetings"
+
+	// A section of the class has a CRN another section in the term has.
+	DuplicateCRN = "duplicate_crn"
+
+	// A meeting of the class ends before it starts.
+	EndBeforeStart = "end_before_start"
+)
+
+// Every check a Validator makes, in the order they are reported.
+var Checks = []string{EmptyName, ZeroCourseNumber, BadCreditHours, NoMeetings, DuplicateCRN, EndBeforeStart}
+
+// Most classes kept as examples of each failed check.
+const maxExamples = 5
+
+type (
+	// Thresholds holds the largest fraction of classes allowed to fail each
+	// check before a scrape is rejected. Checks without a threshold never
+	// reject a scrape.
+	Thresholds map[string]float64
+
+	// Validator checks scraped classes for signs the course API or the
+	// parser went wrong. It is not safe for concurrent use. A nil Validator
+	// checks nothing.
+	Validator struct {
+		result Validation
+
+		// Class holding each CRN of each term.
+		crns map[types.Term]map[int]CourseID
+	}
+
+	// Validation tallies the classes a Validator checked.
+	Validation struct {
+		Classes int
+
+		// Classes failing each check.
+		Failed map[string]int
+
+		// Some of the classes failing each check.
+		Examples map[string][]CourseID
+	}
+
+	// ValidationError is returned when scraped classes fail checks more
+	// often than their thresholds allow.
+	ValidationError struct {
+		Validation Validation
+		Thresholds Thresholds
+
+		// Checks over their threshold.
+		Checks []string
+	}
+)
+
+// DefaultThresholds rejects scrapes with any class that has no name or
+// number, a duplicate CRN or a meeting ending before it starts. Credit hours
+// and meetings are missing from a handful of classes in most terms.
+var DefaultThresholds = Thresholds{
+	EmptyName:        0,
+	ZeroCourseNumber: 0,
+	BadCreditHours:   0.01,
+	NoMeetings:       0.05,
+	DuplicateCRN:     0,
+	EndBeforeStart:   0,
+}
+
+// Construct a Validator that has checked nothing yet.
+func NewValidator() *Validator {
+	return &Validator{
+		result: Validation{
+			Failed:   make(map[string]int),
+			Examples: make(map[string][]CourseID),
+		},
+		crns: make(map[types.Term]map[int]CourseID),
+	}
+}
+
+// Check class, recording every check it fails.
+func (v *Validator) Check(class types.Class) {
+	if v == nil {
+		return
+	}
+
+	v.result.Classes++
+	id := CourseID{Department: class.Department, Number: class.CourseNumber}
+
+	failed := make(map[string]bool)
+	if strings.TrimSpace(class.Name) == "" {
+		failed[EmptyName] = true
+	}
+	if class.CourseNumber == 0 {
+		failed[ZeroCourseNumber] = true
+	}
+	if class.CreditHours == "" {
+		failed[BadCreditHours] = true
+	}
+
+	crns, ok := v.crns[class.Term()]
+	if !ok {
+		crns = make(map[int]CourseID)
+		v.crns[class.Term()] = crns
+	}
+
+	// A class checked again, say by a resumed scrape, keeps its own CRNs.
+	own := make(map[int]bool)
+	for _, section := range class.Sections {
+		if len(section.Meetings) == 0 {
+			failed[NoMeetings] = true
+		}
+
+		if owner, ok := crns[section.CRN]; own[section.CRN] || (ok && owner != id) {
+			failed[DuplicateCRN] = true
+		}
+		crns[section.CRN] = id
+		own[section.CRN] = true
+
+		for _, meeting := range section.Meetings {
+			if meeting.Timed() && meeting.EndMinutes < meeting.StartMinutes {
+				failed[EndBeforeStart] = true
+			}
+		}
+	}
+
+	for check := range failed {
+		v.result.Failed[check]++
+		if len(v.result.Examples[check]) < maxExamples {
+			v.result.Examples[check] = append(v.result.Examples[check], id)
+		}
+	}
+}
+
+// Return the tally of the classes checked so far.
+func (v *Validator) Result() Validation {
+	if v == nil {
+		return Validation{}
+	}
+	return v.result
+}
+
+// Return the fraction of classes checked that failed check.
+func (r Validation) Fraction(check string) float64 {
+	if r.Classes == 0 {
+		return 0
+	}
+	return float64(r.Failed[check]) / float64(r.Classes)
+}
+
+// Return an error if any check failed more often than its threshold allows.
+func (r Validation) Enforce(thresholds Thresholds) error {
+	var over []string
+	for _, check := range Checks {
+		limit, ok := thresholds[check]
+		if ok && r.Failed[check] > 0 && r.Fraction(check) > limit {
+			over = append(over, check)
+		}
+	}
+
+	if len(over) == 0 {
+		return nil
+	}
+	return &ValidationError{Validation: r, Thresholds: thresholds, Checks: over}
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Checks))
+	for _, check := range e.Checks {
+		problems = append(problems, fmt.Sprintf("%s in %d of %d classes (max %g%%)",
+			check, e.Validation.Failed[check], e.Validation.Classes, e.Thresholds[check]*100))
+	}
+	return "scraped classes failed validation: " + strings.Join(problems, ", ")
+}
@@ -0,0 +1,102 @@
+package scrape
+
+import (
+	"testing"
+
+	"github.com/scheedule/coursestore/types"
+)
+
+// Return a class that passes every check.
+func validClass(department string, number, crn int) types.Class {
+	return types.Class{
+		Year:         2016,
+		Semester:     "spring",
+		Department:   department,
+		CourseNumber: number,
+		Name:         "Data Structures",
+		CreditHours:  "4",
+		Sections: []types.Section{{
+			CRN: crn,
+			Meetings: []types.Meeting{
+				{Start: "09:00 AM", End: "09:50 AM", StartMinutes: 540, EndMinutes: 590},
+				{Start: "ARRANGED", StartMinutes: types.NoTime, EndMinutes: types.NoTime},
+			},
+		}},
+	}
+}
+
+func TestValidatorChecks(t *testing.T) {
+	tests := []struct {
+		check  string
+		modify func(c *types.Class)
+	}{
+		{"", func(c *types.Class) {}},
+		{EmptyName, func(c *types.Class) { c.Name = " " }},
+		{ZeroCourseNumber, func(c *types.Class) { c.CourseNumber = 0 }},
+		{BadCreditHours, func(c *types.Class) { c.CreditHours = "" }},
+		{NoMeetings, func(c *types.Class) { c.Sections[0].Meetings = nil }},
+		{DuplicateCRN, func(c *types.Class) { c.Sections[0].CRN = 1 }},
+		{DuplicateCRN, func(c *types.Class) { c.Sections = append(c.Sections, c.Sections[0]) }},
+		{EndBeforeStart, func(c *types.Class) { c.Sections[0].Meetings[0].EndMinutes = 530 }},
+		{EndBeforeStart, func(c *types.Class) { c.Sections[0].Meetings[0].EndMinutes = 0 }},
+		{"", func(c *types.Class) { c.Sections[0].Meetings[0].EndMinutes = types.NoTime }},
+	}
+
+	for _, tt := range tests {
+		v := NewValidator()
+		v.Check(validClass("MATH", 241, 1))
+
+		class := validClass("CS", 225, 2)
+		tt.modify(&class)
+		v.Check(class)
+
+		result := v.Result()
+		for _, check := range Checks {
+			want := 0
+			if check == tt.check {
+				want = 1
+			}
+			if result.Failed[check] != want {
+				t.Errorf("%s: %d classes failed %s, want %d", tt.check, result.Failed[check], check, want)
+			}
+		}
+	}
+}
+
+func TestValidatorRecheck(t *testing.T) {
+	v := NewValidator()
+	v.Check(validClass("CS", 225, 1))
+	v.Check(validClass("CS", 225, 1))
+
+	if n := v.Result().Failed[DuplicateCRN]; n != 0 {
+		t.Errorf("class checked twice failed %s %d times, want 0", DuplicateCRN, n)
+	}
+}
+
+func TestValidationEnforce(t *testing.T) {
+	v := NewValidator()
+	for i := 0; i < 99; i++ {
+		v.Check(validClass("CS", 100+i, 100+i))
+	}
+	class := validClass("CS", 500, 500)
+	class.CreditHours = ""
+	v.Check(class)
+
+	result := v.Result()
+	if err := result.Enforce(DefaultThresholds); err != nil {
+		t.Errorf("Enforce with 1%% bad credit hours returned %v, want nil", err)
+	}
+
+	err := result.Enforce(Thresholds{BadCreditHours: 0})
+	verr, ok := err.(*ValidationError)
+	if !ok || len(verr.Checks) != 1 || verr.Checks[0] != BadCreditHours {
+		t.Fatalf("Enforce with no bad credit hours allowed returned %v", err)
+	}
+	if examples := verr.Validation.Examples[BadCreditHours]; len(examples) != 1 || examples[0] != (CourseID{"CS", 500}) {
+		t.Errorf("examples of %s were %v, want [CS 500]", BadCreditHours, examples)
+	}
+
+	if err = result.Enforce(nil); err != nil {
+		t.Errorf("Enforce without thresholds returned %v, want nil", err)
+	}
+}
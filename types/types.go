@@ -2,9 +2,40 @@
 // Types are tagged for xml unmarshalling and bson serializing.
 package types
 
-import "gopkg.in/mgo.v2/bson"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Order of semesters within a calendar year. UIUC's winter session starts in
+// December but belongs to the following calendar year.
+var semesterOrder = map[string]int{
+	"winter": 0,
+	"spring": 1,
+	"summer": 2,
+	"fall":   3,
+}
+
+// Minutes of a meeting time that isn't a time, like "ARRANGED".
+const NoTime = -1
+
+// Parts of the term a section can meet in.
+const (
+	FullTerm         = "full"
+	FirstEightWeeks  = "first8"
+	SecondEightWeeks = "second8"
+)
 
 type (
+	// Term identifies a semester classes are offered in, like spring 2016.
+	Term struct {
+		Year     int    `bson:"year" json:"year"`
+		Semester string `bson:"semester" json:"semester"`
+	}
+
 	// Type to unmarshal instructor types from the UIUC CISAPI
 	Instructor struct {
 		FirstName string `xml:"firstName,attr" bson:"first" json:"first"`
@@ -17,6 +48,35 @@ type (
 		Code string `xml:"code,attr" bson:"code" json:"code"`
 	}
 
+	// Weekdays is a set of days of the week, holding bit 1<<d for each
+	// time.Weekday d in it.
+	Weekdays uint8
+
+	// Location is where a meeting is held. Empty for meetings without a
+	// room, like online ones.
+	Location struct {
+		Building string `bson:"building" json:"building,omitempty"`
+		Room     string `bson:"room" json:"room,omitempty"`
+	}
+
+	// Building lists the rooms of a building meetings are held in.
+	Building struct {
+		Name     string   `json:"name"`
+		Rooms    []string `json:"rooms"`
+		Meetings int      `json:"meetings"`
+	}
+
+	// BuildingMeeting is a meeting held in a building, with the class and
+	// section it belongs to.
+	BuildingMeeting struct {
+		Department   string  `json:"department"`
+		CourseNumber int     `json:"courseNumber"`
+		Name         string  `json:"name"`
+		CRN          int     `json:"crn"`
+		Code         string  `json:"code"`
+		Meeting      Meeting `json:"meeting"`
+	}
+
 	// Type to unmarshal meeting data from the UIUC CISAPI
 	Meeting struct {
 		Type        CourseType   `xml:"type" bson:"type" json:"type"`
@@ -24,7 +84,16 @@ type (
 		End         string       `xml:"end" bson:"end" json:"end"`
 		Days        string       `xml:"daysOfTheWeek" bson:"days" json:"days"`
 		Building    string       `xml:"buildingName" bson:"building" json:"building,omitempty"`
+		Room        string       `xml:"roomNumber" bson:"room" json:"room,omitempty"`
 		Instructors []Instructor `xml:"instructors>instructor" bson:"instructors" json:"instructors,omitempty"`
+
+		// Start and End in minutes since midnight. NoTime if they aren't
+		// times, like for a meeting arranged with the instructor.
+		StartMinutes int `xml:"-" bson:"start_minutes" json:"startMinutes"`
+		EndMinutes   int `xml:"-" bson:"end_minutes" json:"endMinutes"`
+
+		// The days in Days.
+		Weekdays Weekdays `xml:"-" bson:"weekdays" json:"weekdays,omitempty"`
 	}
 
 	// Type to unmarshal section data from the UIUC CISAPI
@@ -35,17 +104,236 @@ type (
 		Start            string    `xml:"startDate" bson:"start" json:"start,omitempty"`
 		End              string    `xml:"endDate" bson:"end" json:"end,omitempty"`
 		Meetings         []Meeting `xml:"meetings>meeting" bson:"meetings" json:"meetings"`
+		Title            string    `xml:"sectionTitle" bson:"title" json:"title,omitempty"`
+		Text             string    `xml:"sectionText" bson:"text" json:"text,omitempty"`
+		Notes            string    `xml:"sectionNotes" bson:"notes" json:"notes,omitempty"`
+		PartOfTermCode   string    `xml:"partOfTerm" bson:"part_of_term_code" json:"partOfTermCode,omitempty"`
+		StatusCode       string    `xml:"sectionStatusCode" bson:"status_code" json:"statusCode,omitempty"`
+		SpecialApproval  string    `xml:"specialApproval" bson:"special_approval" json:"specialApproval,omitempty"`
+
+		// Credit hours of sections that override their class's, in the
+		// same form as the class's. Empty if not overridden.
+		CreditHours    string  `xml:"creditHours" bson:"credit_hours" json:"creditHours,omitempty"`
+		MinCreditHours float64 `xml:"-" bson:"min_credit_hours" json:"minCreditHours,omitempty"`
+		MaxCreditHours float64 `xml:"-" bson:"max_credit_hours" json:"maxCreditHours,omitempty"`
+
+		// Start and End as dates. Zero if unknown.
+		StartDate time.Time `xml:"-" bson:"start_date" json:"startDate"`
+		EndDate   time.Time `xml:"-" bson:"end_date" json:"endDate"`
+
+		// The part of the term the section meets in: FullTerm,
+		// FirstEightWeeks or SecondEightWeeks. Empty if unknown.
+		PartOfTerm string `xml:"-" bson:"part_of_term" json:"partOfTerm,omitempty"`
+	}
+
+	// GenEd is a general education requirement a class satisfies, like
+	// Western Comparative Cultures in the Cultural Studies category.
+	GenEd struct {
+		Category     string `bson:"category" json:"category"`
+		CategoryName string `bson:"category_name" json:"categoryName"`
+		Code         string `bson:"code" json:"code,omitempty"`
+		Name         string `bson:"name" json:"name,omitempty"`
 	}
 
 	// Type to unmarshal class data from the UIUC CISAPI
 	Class struct {
-		ID               bson.ObjectId `bson:"_id,omitempty" json:"-"`
-		Department       string        `bson:"department" json:"department"`
-		CourseNumber     int           `bson:"course_number" json:"courseNumber"`
-		Name             string        `bson:"name" json:"name"`
-		Description      string        `bson:"description" json:"description,omitempty"`
-		CreditHours      string        `bson:"credit_hours" json:"creditHours,omitempty"`
-		DegreeAttributes []string      `bson:"degree_attributes" json:"degreeAttributes,omitempty"`
-		Sections         []Section     `bson:"sections" json:"sections,omitempty"`
+		ID             bson.ObjectId `bson:"_id,omitempty" json:"-"`
+		Year           int           `bson:"year" json:"year"`
+		Semester       string        `bson:"semester" json:"semester"`
+		Department     string        `bson:"department" json:"department"`
+		CourseNumber   int           `bson:"course_number" json:"courseNumber"`
+		Name           string        `bson:"name" json:"name"`
+		Description    string        `bson:"description" json:"description,omitempty"`
+		CreditHours    string        `bson:"credit_hours" json:"creditHours,omitempty"`
+		MinCreditHours float64       `bson:"min_credit_hours" json:"minCreditHours"`
+		MaxCreditHours float64       `bson:"max_credit_hours" json:"maxCreditHours"`
+		GenEds         []GenEd       `bson:"gen_eds" json:"genEds,omitempty"`
+		Sections       []Section     `bson:"sections" json:"sections,omitempty"`
+
+		// Deprecated: use GenEds. The GenEds in the course API's prose,
+		// kept populated for one more release so clients can move over.
+		DegreeAttributes []string `bson:"degree_attributes" json:"degreeAttributes,omitempty"`
+	}
+
+	// ScrapeCounts tallies the changes a scrape made to the store.
+	ScrapeCounts struct {
+		Added     int `bson:"added" json:"added"`
+		Updated   int `bson:"updated" json:"updated"`
+		Unchanged int `bson:"unchanged" json:"unchanged"`
+		Removed   int `bson:"removed" json:"removed"`
+
+		// Courses not fetched because they are unmodified since the last
+		// scrape.
+		NotModified int `bson:"not_modified" json:"notModified"`
+
+		// Courses skipped because they failed to scrape.
+		Failed int `bson:"failed" json:"failed"`
+	}
+
+	// ScrapeFailure records a document a scrape failed to fetch or digest.
+	ScrapeFailure struct {
+		URL        string `bson:"url" json:"url"`
+		Department string `bson:"department" json:"department,omitempty"`
+		Error      string `bson:"error" json:"error"`
+	}
+
+	// ScrapeRun records one run of the scraper, whether or not it succeeded.
+	ScrapeRun struct {
+		ID       bson.ObjectId `bson:"_id,omitempty" json:"-"`
+		Start    time.Time     `bson:"start" json:"start"`
+		End      time.Time     `bson:"end" json:"end"`
+		TermURLs []string      `bson:"term_urls" json:"termURLs"`
+
+		// Why the run stopped. Empty if it succeeded.
+		Error string `bson:"error" json:"error,omitempty"`
+
+		ScrapeCounts `bson:",inline"`
+		Departments  map[string]ScrapeCounts `bson:"departments" json:"departments"`
+		Failures     []ScrapeFailure         `bson:"failures" json:"failures,omitempty"`
+
+		// Classes stored that failed each validation check.
+		Invalid map[string]int `bson:"invalid" json:"invalid,omitempty"`
+
+		// Requests made to the course API, the responses received by status
+		// code, and the bytes of response bodies read.
+		Requests     int            `bson:"requests" json:"requests"`
+		Statuses     map[string]int `bson:"statuses" json:"statuses"`
+		BytesFetched int64          `bson:"bytes_fetched" json:"bytesFetched"`
+	}
+
+	// DocumentValidators are the HTTP validators of a document fetched from
+	// the course API. A conditional request for the document sends them so
+	// the API can answer 304 Not Modified if it hasn't changed.
+	DocumentValidators struct {
+		ETag         string `bson:"etag" json:"etag,omitempty"`
+		LastModified string `bson:"last_modified" json:"lastModified,omitempty"`
 	}
 )
+
+// Return how many classes the scrape added, updated or removed.
+func (c ScrapeCounts) Changed() int {
+	return c.Added + c.Updated + c.Removed
+}
+
+// Return true if day is in the set.
+func (w Weekdays) Has(day time.Weekday) bool {
+	return w&(1<<uint(day)) != 0
+}
+
+// Return the days in the set, starting with Sunday.
+func (w Weekdays) List() []time.Weekday {
+	var days []time.Weekday
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if w.Has(day) {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// Return the Building and Room of the meeting together.
+func (m Meeting) Location() Location {
+	return Location{Building: m.Building, Room: m.Room}
+}
+
+// Marshal the meeting to JSON along with its Location, which is derived
+// rather than stored.
+func (m Meeting) MarshalJSON() ([]byte, error) {
+	type meeting Meeting
+	return json.Marshal(struct {
+		meeting
+		Location Location `json:"location"`
+	}{meeting(m), m.Location()})
+}
+
+// Return true if the meeting has a set start and end time.
+func (m Meeting) Timed() bool {
+	return m.StartMinutes != NoTime && m.EndMinutes != NoTime
+}
+
+// Unmarshal a stored meeting. Meetings stored before their times were parsed
+// into minutes have none, so are decoded with NoTime rather than midnight.
+func (m *Meeting) SetBSON(raw bson.Raw) error {
+	type meeting Meeting
+	var decoded meeting
+	if err := raw.Unmarshal(&decoded); err != nil {
+		return err
+	}
+
+	var minutes struct {
+		Start *int `bson:"start_minutes"`
+		End   *int `bson:"end_minutes"`
+	}
+	if err := raw.Unmarshal(&minutes); err != nil {
+		return err
+	}
+	if minutes.Start == nil {
+		decoded.StartMinutes = NoTime
+	}
+	if minutes.End == nil {
+		decoded.EndMinutes = NoTime
+	}
+
+	*m = Meeting(decoded)
+	return nil
+}
+
+// Return true if the class can be taken for some number of credit hours
+// between min and max, either the class's own or those a section overrides
+// them with. Unknown credit hours never match.
+func (c Class) OffersCredits(min, max float64) bool {
+	if c.CreditHours != "" && c.MinCreditHours <= max && c.MaxCreditHours >= min {
+		return true
+	}
+
+	for _, s := range c.Sections {
+		if s.CreditHours != "" && s.MinCreditHours <= max && s.MaxCreditHours >= min {
+			return true
+		}
+	}
+	return false
+}
+
+// Return true if the class satisfies a GenEd with the category or attribute
+// code given, regardless of case.
+func (c Class) SatisfiesGenEd(code string) bool {
+	for _, genEd := range c.GenEds {
+		if strings.EqualFold(genEd.Category, code) || strings.EqualFold(genEd.Code, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the Term the class is offered in.
+func (c Class) Term() Term {
+	return Term{Year: c.Year, Semester: c.Semester}
+}
+
+// Return true if t comes before other. Unknown semesters sort last within
+// their year.
+func (t Term) Before(other Term) bool {
+	if t.Year != other.Year {
+		return t.Year < other.Year
+	}
+
+	order, ok := semesterOrder[t.Semester]
+	if !ok {
+		order = len(semesterOrder)
+	}
+	otherOrder, ok := semesterOrder[other.Semester]
+	if !ok {
+		otherOrder = len(semesterOrder)
+	}
+
+	if order != otherOrder {
+		return order < otherOrder
+	}
+	return t.Semester < other.Semester
+}
+
+// Return true if semester is one offered by the university.
+func IsSemester(semester string) bool {
+	_, ok := semesterOrder[semester]
+	return ok
+}